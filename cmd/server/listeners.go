@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/config"
+	"github.com/siddhu949/leanbalancer/internal/h2"
+	"github.com/siddhu949/leanbalancer/internal/minrate"
+	"github.com/siddhu949/leanbalancer/internal/proxy"
+	"github.com/siddhu949/leanbalancer/internal/proxyproto"
+	"github.com/siddhu949/leanbalancer/internal/realip"
+	"github.com/siddhu949/leanbalancer/internal/tlsconfig"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+var (
+	tlsSettings     config.TLSConfig
+	listenerConfigs []config.ListenerConfig
+)
+
+// newServer builds the fasthttp server for the proxy listeners
+func newServer(handler fasthttp.RequestHandler) *fasthttp.Server {
+	return &fasthttp.Server{
+		Handler:            handler,
+		ErrorHandler:       requestError,
+		HeaderReceived:     routeRequestConfig,
+		StreamRequestBody:  streamingEnabled,
+		ReadBufferSize:     maxHeaderSize,
+		MaxRequestBodySize: maxBodySize,
+		ReadTimeout:        readTimeout,
+		WriteTimeout:       writeTimeout,
+		IdleTimeout:        idleTimeout,
+		MaxConnsPerIP:      maxConnsPerIP,
+	}
+}
+
+// requestError answers requests the server could not read: 431 for large
+// headers, 413 for large bodies and 408 for slow clients
+func requestError(ctx *fasthttp.RequestCtx, err error) {
+	var smallBuffer *fasthttp.ErrSmallBuffer
+	var netErr net.Error
+	switch {
+	case errors.As(err, &smallBuffer):
+		ctx.Error("Request header too large", fasthttp.StatusRequestHeaderFieldsTooLarge)
+	case errors.Is(err, fasthttp.ErrBodyTooLarge):
+		ctx.Error("Request body too large", fasthttp.StatusRequestEntityTooLarge)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		ctx.Error("Request timeout", fasthttp.StatusRequestTimeout)
+	default:
+		ctx.Error("Error when parsing request", fasthttp.StatusBadRequest)
+	}
+}
+
+// routeRequestConfig applies a route's body size limit once the request
+// headers are read
+func routeRequestConfig(header *fasthttp.RequestHeader) fasthttp.RequestConfig {
+	path := header.RequestURI()
+	if i := bytes.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	if route := proxy.MatchRoute(string(path)); route != nil && route.MaxBodySize > 0 {
+		return fasthttp.RequestConfig{MaxRequestBodySize: route.MaxBodySize}
+	}
+	return fasthttp.RequestConfig{}
+}
+
+// h2Limits holds HTTP/2 connections to the same limits as newServer
+func h2Limits() h2.Limits {
+	return h2.Limits{
+		MaxHeaderSize: maxHeaderSize,
+		ReadTimeout:   readTimeout,
+		WriteTimeout:  writeTimeout,
+		IdleTimeout:   idleTimeout,
+		MaxConnsPerIP: maxConnsPerIP,
+		MaxBodySize: func(path string) int {
+			if route := proxy.MatchRoute(path); route != nil && route.MaxBodySize > 0 {
+				return route.MaxBodySize
+			}
+			return maxBodySize
+		},
+		StreamRequestBody: streamingEnabled,
+	}
+}
+
+// listen opens an HTTP listener on port, optionally parsing PROXY protocol
+// headers, with the minimum transfer rate applied
+func listen(port int, acceptProxyProtocol bool) (net.Listener, error) {
+	ln, err := listenL4(port, acceptProxyProtocol)
+	if err != nil {
+		return nil, err
+	}
+	if minTransferRate > 0 {
+		ln = minrate.NewListener(ln, minTransferRate)
+	}
+	return ln, nil
+}
+
+// listenL4 opens a TCP listener on port, optionally parsing PROXY protocol
+// headers. Raw TCP streams have no requests to time, so no rate applies.
+func listenL4(port int, acceptProxyProtocol bool) (net.Listener, error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	if acceptProxyProtocol {
+		var trusted func(net.IP) bool
+		if len(trustedProxies) > 0 {
+			trusted = realip.IsTrustedProxy
+		}
+		ln = proxyproto.NewListener(ln, trusted, true)
+	}
+	return ln, nil
+}
+
+// startTLSServer serves the proxy over HTTPS with SNI certificate selection
+// and reloads certificates on file change or SIGHUP
+func startTLSServer(log *zap.Logger) {
+	pairs := make([]tlsconfig.CertPair, len(tlsSettings.Certificates))
+	for i, c := range tlsSettings.Certificates {
+		pairs[i] = tlsconfig.CertPair{CertFile: c.CertFile, KeyFile: c.KeyFile}
+	}
+	store, err := tlsconfig.NewCertStore(pairs)
+	if err != nil {
+		log.Fatal("Error loading TLS certificates", zap.Error(err))
+	}
+	tlsCfg, err := tlsconfig.NewServerConfig(store, tlsSettings.MinVersion, tlsSettings.CipherSuites)
+	if err != nil {
+		log.Fatal("Invalid TLS settings", zap.Error(err))
+	}
+	if tlsSettings.HTTP2 {
+		tlsCfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	reloadInterval := 10 * time.Second
+	if d, err := time.ParseDuration(tlsSettings.ReloadInterval); err == nil && d > 0 {
+		reloadInterval = d
+	}
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	go store.Watch(watchCtx, reloadInterval, func(err error) {
+		log.Error("TLS certificate reload failed, keeping previous certificates", zap.Error(err))
+	})
+
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if err := store.Reload(); err != nil {
+				log.Error("TLS certificate reload failed, keeping previous certificates", zap.Error(err))
+			} else {
+				log.Info("TLS certificates reloaded")
+			}
+		}
+	}()
+
+	ln, err := listen(tlsSettings.Port, proxyProtocol)
+	if err != nil {
+		log.Fatal("Error starting TLS listener", zap.Error(err))
+	}
+
+	go func() {
+		log.Info("🔒 LeanBalancer TLS running on port", zap.Int("port", tlsSettings.Port))
+		tlsLn := tls.NewListener(ln, tlsCfg)
+		if tlsSettings.HTTP2 {
+			tlsLn = h2.NewListener(tlsLn, requestHandler, false, h2Limits())
+		}
+		err := newServer(requestHandler).Serve(tlsLn)
+		stopWatching()
+		if err != nil {
+			log.Fatal("Error serving TLS", zap.Error(err))
+		}
+	}()
+
+	if tlsSettings.HTTPRedirectPort != 0 {
+		go startRedirectServer(log)
+	}
+}
+
+// startRedirectServer redirects plain HTTP requests to the HTTPS listener
+func startRedirectServer(log *zap.Logger) {
+	ln, err := listen(tlsSettings.HTTPRedirectPort, proxyProtocol)
+	if err != nil {
+		log.Fatal("Error starting HTTP redirect listener", zap.Error(err))
+	}
+
+	log.Info("↪️ HTTP to HTTPS redirect running on port", zap.Int("port", tlsSettings.HTTPRedirectPort))
+	if err := (&fasthttp.Server{Handler: redirectToHTTPS}).Serve(ln); err != nil {
+		log.Fatal("Error serving HTTP redirect", zap.Error(err))
+	}
+}
+
+func redirectToHTTPS(ctx *fasthttp.RequestCtx) {
+	host := string(ctx.Host())
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if tlsSettings.Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(tlsSettings.Port))
+	}
+
+	status := fasthttp.StatusMovedPermanently
+	if !ctx.IsGet() && !ctx.IsHead() {
+		status = fasthttp.StatusPermanentRedirect // Keep the method and body
+	}
+	ctx.Redirect("https://"+host+string(ctx.RequestURI()), status)
+}
+
+// startL4Listeners starts the layer-4 listeners, e.g. mode: tcp or udp
+func startL4Listeners(log *zap.Logger) {
+	for _, lc := range listenerConfigs {
+		p := proxy.GetPool(lc.Pool)
+		if p == nil {
+			log.Fatal("Invalid listener", zap.String("listener", lc.Name), zap.String("unknown_pool", lc.Pool))
+		}
+
+		connectTimeout := 3 * time.Second
+		if d, err := time.ParseDuration(lc.ConnectTimeout); err == nil {
+			connectTimeout = d
+		}
+		idleTimeout := 5 * time.Minute
+		if d, err := time.ParseDuration(lc.IdleTimeout); err == nil {
+			idleTimeout = d
+		}
+		sendProxyProtocol, err := proxyproto.ParseVersion(lc.SendProxyProtocol)
+		if err != nil {
+			log.Fatal("Invalid listener", zap.String("listener", lc.Name), zap.Error(err))
+		}
+		if lc.SendProxyProtocol == "" {
+			sendProxyProtocol = p.SendProxyProtocol
+		}
+
+		switch lc.Mode {
+		case "tcp":
+			ln, err := listenL4(lc.Port, lc.ProxyProtocol)
+			if err != nil {
+				log.Fatal("Error starting TCP listener", zap.String("listener", lc.Name), zap.Error(err))
+			}
+			tp := &proxy.TCPProxy{
+				Name:              lc.Name,
+				Pool:              p,
+				ConnectTimeout:    connectTimeout,
+				IdleTimeout:       idleTimeout,
+				SendProxyProtocol: sendProxyProtocol,
+			}
+			go func(name string, port int) {
+				log.Info("🔌 TCP listener running on port", zap.String("listener", name), zap.Int("port", port))
+				if err := tp.Serve(ln); err != nil {
+					log.Fatal("Error serving TCP listener", zap.String("listener", name), zap.Error(err))
+				}
+			}(lc.Name, lc.Port)
+
+		case "udp":
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: lc.Port})
+			if err != nil {
+				log.Fatal("Error starting UDP listener", zap.String("listener", lc.Name), zap.Error(err))
+			}
+			sessionTimeout := 30 * time.Second
+			if d, err := time.ParseDuration(lc.SessionTimeout); err == nil {
+				sessionTimeout = d
+			}
+			up := &proxy.UDPProxy{
+				Name:           lc.Name,
+				Pool:           p,
+				SessionTimeout: sessionTimeout,
+				MaxSessions:    lc.MaxSessions,
+				Affinity:       lc.Affinity,
+			}
+			go func(name string, port int) {
+				log.Info("📡 UDP listener running on port", zap.String("listener", name), zap.Int("port", port))
+				if err := up.Serve(conn); err != nil {
+					log.Fatal("Error serving UDP listener", zap.String("listener", name), zap.Error(err))
+				}
+			}(lc.Name, lc.Port)
+
+		default:
+			log.Fatal("Invalid listener mode", zap.String("listener", lc.Name), zap.String("mode", lc.Mode))
+		}
+	}
+}
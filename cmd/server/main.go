@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,24 +14,66 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	v1 "github.com/siddhu949/leanbalancer/api/v1"
 	"github.com/siddhu949/leanbalancer/internal/admin"
+	"github.com/siddhu949/leanbalancer/internal/cache"
+	"github.com/siddhu949/leanbalancer/internal/compress"
+	"github.com/siddhu949/leanbalancer/internal/config"
+	"github.com/siddhu949/leanbalancer/internal/egress"
+	"github.com/siddhu949/leanbalancer/internal/errorpages"
 	"github.com/siddhu949/leanbalancer/internal/firewall"
-	"github.com/siddhu949/leanbalancer/internal/health"
+	"github.com/siddhu949/leanbalancer/internal/h2"
 	"github.com/siddhu949/leanbalancer/internal/logger"
 	"github.com/siddhu949/leanbalancer/internal/metrics"
 	"github.com/siddhu949/leanbalancer/internal/proxy"
+	"github.com/siddhu949/leanbalancer/internal/proxyauth"
+	"github.com/siddhu949/leanbalancer/internal/realip"
+	"github.com/siddhu949/leanbalancer/pkg/pool"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/fasthttpadaptor"
 	"go.uber.org/zap"
 )
 
+var configPath = flag.String("config", "configs/config.yaml", "path to the LeanBalancer config file")
+
 var (
 	serverPort            = 8080
 	metricsPort           = 9090
+	adminToken            string
 	loadBalancerAlgorithm = "round_robin"
 	timeout               = 5 * time.Second
 
-	firewallEnabled = true
-	//blockedIPs      = []string{"192.168.1.100", "10.0.0.1"}
+	streamingEnabled  = false
+	streamIdleTimeout = 30 * time.Second
+
+	forwardProxyEnabled        = false
+	forwardProxyConnectTimeout = 5 * time.Second
+	forwardProxyTimeout        = 30 * time.Second
+	forwardProxyIdleTimeout    = 5 * time.Minute
+	egressPolicy               config.EgressPolicyConfig
+	proxyAuth                  config.ProxyAuthConfig
+
+	cacheSettings config.CacheConfig
+
+	compressionEnabled bool
+	compressionTypes   []string
+	compressionMinSize int
+	compressionLevel   int
+
+	trustedProxies []string
+	proxyProtocol  bool
+	h2cEnabled     bool
+
+	maxHeaderSize   = 8 * 1024
+	maxBodySize     = 4 * 1024 * 1024
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	maxConnsPerIP   int
+	minTransferRate int
+
+	firewallEnabled       = true
+	firewallBlockedIPs    []string
+	firewallRateLimit     = config.RateLimitConfig{Requests: 100, Period: "1m"}
+	firewallBlockDuration = 5 * time.Minute
 
 	healthCheckEnabled  = true
 	healthCheckInterval = 5 * time.Second
@@ -44,14 +87,24 @@ var (
 // LeanBalancer handler
 func requestHandler(ctx *fasthttp.RequestCtx) {
 	defer func() {
-		log.Printf("Responded with status: %d to path: %s", ctx.Response.StatusCode(), ctx.Path())
+		log.Printf("Responded with status: %d to path: %s for client: %s", ctx.Response.StatusCode(), ctx.Path(), realip.ClientIP(ctx))
+	}()
+	defer func() {
+		var pages *errorpages.Pages
+		if route := proxy.MatchRoute(string(ctx.Path())); route != nil {
+			pages = route.ErrorPages
+		}
+		errorpages.Render(ctx, pages)
 	}()
 
 	// Firewall check
 	if firewallEnabled && !firewall.FirewallMiddleware(ctx) {
-		ctx.SetStatusCode(fasthttp.StatusForbidden)
-		ctx.SetContentType("text/plain")
-		ctx.SetBody([]byte("Access denied"))
+		return
+	}
+
+	// CONNECT and absolute-form requests are for the forward proxy, whatever the path
+	if proxy.IsForwardProxyRequest(ctx) {
+		proxy.ForwardProxyHandler(ctx)
 		return
 	}
 
@@ -63,6 +116,7 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 
 	case "/reverse":
 		proxy.ReverseProxyHandler(ctx)
+		compress.Response(ctx)
 
 	case "/forward":
 		proxy.ForwardProxyHandler(ctx)
@@ -76,10 +130,104 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 		fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())(ctx)
 
 	default:
-		ctx.SetStatusCode(fasthttp.StatusNotFound)
-		ctx.SetContentType("text/plain")
-		ctx.SetBody([]byte("404 - Not Found"))
+		if route := proxy.MatchRoute(string(ctx.Path())); route != nil {
+			proxy.RouteHandler(ctx, route)
+			compress.Response(ctx)
+			return
+		}
+
+		errorpages.Error(ctx, "404 - Not Found", fasthttp.StatusNotFound)
+	}
+}
+
+// applyConfig overrides the built-in defaults with values from the config file
+func applyConfig(cfg *config.Config) {
+	if cfg.Server.Port != 0 {
+		serverPort = cfg.Server.Port
+	}
+	if cfg.Server.MetricsPort != 0 {
+		metricsPort = cfg.Server.MetricsPort
+	}
+	adminToken = cfg.Server.AdminToken
+	trustedProxies = cfg.Server.TrustedProxies
+	proxyProtocol = cfg.Server.ProxyProtocol
+	h2cEnabled = cfg.Server.H2C
+	if cfg.Server.Limits.MaxHeaderSize > 0 {
+		maxHeaderSize = cfg.Server.Limits.MaxHeaderSize
+	}
+	if cfg.Server.Limits.MaxBodySize > 0 {
+		maxBodySize = cfg.Server.Limits.MaxBodySize
+	}
+	if d, err := time.ParseDuration(cfg.Server.Limits.ReadTimeout); err == nil {
+		readTimeout = d
+	}
+	if d, err := time.ParseDuration(cfg.Server.Limits.WriteTimeout); err == nil {
+		writeTimeout = d
+	}
+	if d, err := time.ParseDuration(cfg.Server.Limits.IdleTimeout); err == nil {
+		idleTimeout = d
+	}
+	maxConnsPerIP = cfg.Server.Limits.MaxConnsPerIP
+	minTransferRate = cfg.Server.Limits.MinTransferRate
+	tlsSettings = cfg.TLS
+
+	if cfg.LoadBalancer.Algorithm != "" {
+		loadBalancerAlgorithm = cfg.LoadBalancer.Algorithm
+	}
+	if d, err := time.ParseDuration(cfg.LoadBalancer.Timeout); err == nil {
+		timeout = d
+	}
+	if d, err := time.ParseDuration(cfg.LoadBalancer.UpgradeIdleTimeout); err == nil {
+		proxy.SetUpgradeIdleTimeout(d)
+	}
+	streamingEnabled = cfg.LoadBalancer.Streaming
+	if d, err := time.ParseDuration(cfg.LoadBalancer.StreamIdleTimeout); err == nil {
+		streamIdleTimeout = d
+	}
+
+	forwardProxyEnabled = cfg.ForwardProxy.Enabled
+	if d, err := time.ParseDuration(cfg.ForwardProxy.ConnectTimeout); err == nil {
+		forwardProxyConnectTimeout = d
+	}
+	if d, err := time.ParseDuration(cfg.ForwardProxy.Timeout); err == nil {
+		forwardProxyTimeout = d
+	}
+	if d, err := time.ParseDuration(cfg.ForwardProxy.TunnelIdleTimeout); err == nil {
+		forwardProxyIdleTimeout = d
+	}
+	egressPolicy = cfg.ForwardProxy.Egress
+	proxyAuth = cfg.ForwardProxy.Auth
+
+	firewallEnabled = cfg.Firewall.Enabled
+	firewallBlockedIPs = cfg.Firewall.BlockedIPs
+	if cfg.Firewall.RateLimit.Requests != 0 {
+		firewallRateLimit = cfg.Firewall.RateLimit
+	}
+	if cfg.Firewall.RateLimit.MaxKeys != 0 {
+		firewallRateLimit.MaxKeys = cfg.Firewall.RateLimit.MaxKeys
+	}
+	if d, err := time.ParseDuration(cfg.Firewall.BlockDuration); err == nil {
+		firewallBlockDuration = d
+	}
+
+	healthCheckEnabled = cfg.HealthCheck.Enabled
+	if d, err := time.ParseDuration(cfg.HealthCheck.Interval); err == nil {
+		healthCheckInterval = d
+	}
+	if len(cfg.HealthCheck.Backends) > 0 {
+		healthCheckBackends = cfg.HealthCheck.Backends
 	}
+	healthCheckTLS = cfg.HealthCheck.TLS
+
+	cacheSettings = cfg.Cache
+	compressionEnabled = cfg.Compression.Enabled
+	compressionTypes = cfg.Compression.Types
+	compressionMinSize = cfg.Compression.MinSize
+	compressionLevel = cfg.Compression.Level
+	errorPageConfigs = cfg.ErrorPages
+	poolConfigs = cfg.Pools
+	routeConfigs = cfg.Routes
+	listenerConfigs = cfg.Listeners
 }
 
 // Graceful shutdown logic
@@ -99,22 +247,89 @@ func gracefulShutdown(srv *http.Server) {
 }
 
 func main() {
+	flag.Parse()
+
 	// Init logger
 	logger.InitLogger()
 	log := logger.GetLogger()
 
+	// Load config, keeping the built-in defaults if it is missing
+	if cfg, err := config.LoadConfig(*configPath); err != nil {
+		log.Warn("Using built-in defaults", zap.String("config", *configPath), zap.Error(err))
+	} else {
+		applyConfig(cfg)
+	}
+
+	// Trusted proxies for real client IP resolution
+	if err := realip.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("Invalid trusted_proxies", zap.Error(err))
+	}
+	if firewallEnabled {
+		if err := firewall.SetBlockedIPs(firewallBlockedIPs); err != nil {
+			log.Fatal("Invalid firewall blocked_ips", zap.Error(err))
+		}
+		limiter, err := rateLimit(firewallRateLimit)
+		if err != nil {
+			log.Fatal("Invalid firewall rate_limit", zap.Error(err))
+		}
+		firewall.SetRateLimit(limiter, firewallBlockDuration)
+	}
+
+	if err := egress.SetRules(egress.Rules{
+		AllowedHosts: egressPolicy.AllowedHosts,
+		DeniedHosts:  egressPolicy.DeniedHosts,
+		AllowedCIDRs: egressPolicy.AllowedCIDRs,
+		DeniedCIDRs:  egressPolicy.DeniedCIDRs,
+		AllowedPorts: egressPolicy.AllowedPorts,
+		DeniedPorts:  egressPolicy.DeniedPorts,
+		AllowPrivate: egressPolicy.AllowPrivate,
+	}); err != nil {
+		log.Fatal("Invalid forward_proxy egress policy", zap.Error(err))
+	}
+	if proxyAuth.Enabled {
+		setupProxyAuth(log)
+	}
+	proxy.SetForwardProxy(forwardProxyEnabled, forwardProxyConnectTimeout, forwardProxyTimeout, forwardProxyIdleTimeout)
+	if forwardProxyEnabled {
+		log.Info("Forward proxy enabled", zap.Duration("connect_timeout", forwardProxyConnectTimeout), zap.Duration("timeout", forwardProxyTimeout))
+	}
+
 	// Fiber for Admin/API
 	app := fiber.New()
+	admin.Protect(app, adminToken)
 	v1.SetupRoutes(app)
 	admin.RegisterAdminRoutes(app)
+	admin.RegisterSplitRoutes(app)
+	admin.RegisterMaintenanceRoutes(app)
+
+	// On-the-fly compression of proxied responses
+	if compressionEnabled {
+		compress.Setup(compressionTypes, compressionMinSize, compressionLevel)
+		log.Info("Response compression enabled", zap.Strings("types", compressionTypes))
+	}
 
-	// Health Check Setup
-	if healthCheckEnabled {
-		healthChecker := health.NewHealthChecker(healthCheckBackends, healthCheckInterval)
-		go healthChecker.CheckHealth()
-		log.Info("Health checks enabled", zap.Int("backends", len(healthCheckBackends)))
+	// Response cache for routes with cache: true
+	if cacheSettings.Enabled {
+		c := setupCache(log)
+		proxy.SetCache(c)
+		if d, err := time.ParseDuration(cacheSettings.CoalesceTimeout); err == nil {
+			proxy.SetCoalesceTimeout(d)
+		}
+		admin.RegisterCacheRoutes(app, c)
 	}
 
+	// Body streaming must be set before any backend client is created
+	if streamingEnabled {
+		pool.EnableStreaming(streamIdleTimeout)
+		log.Info("Body streaming enabled", zap.Duration("idle_timeout", streamIdleTimeout))
+	}
+
+	// Backend pools, health checks and routes
+	setupPools(log)
+
+	// Layer-4 listeners
+	startL4Listeners(log)
+
 	// Register Prometheus metrics
 	metrics.RegisterMetrics()
 
@@ -132,12 +347,85 @@ func main() {
 		}
 	}()
 
+	// Start HTTPS listener
+	if tlsSettings.Enabled {
+		startTLSServer(log)
+	}
+
 	// Start main LeanBalancer proxy server
+	ln, err := listen(serverPort, proxyProtocol)
+	if err != nil {
+		log.Fatal("Error starting LeanBalancer", zap.Error(err))
+	}
+	if proxyProtocol {
+		log.Info("PROXY protocol enabled on listeners")
+	}
+	if h2cEnabled {
+		ln = h2.NewListener(ln, requestHandler, true, h2Limits())
+		log.Info("h2c enabled on listener")
+	}
+
 	log.Info("🚀 LeanBalancer running on port", zap.Int("port", serverPort))
-	if err := fasthttp.ListenAndServe(fmt.Sprintf(":%d", serverPort), requestHandler); err != nil {
+	if err := newServer(requestHandler).Serve(ln); err != nil {
 		log.Fatal("Error starting LeanBalancer", zap.Error(err))
 	}
 
 	// Handle graceful shutdown
 	gracefulShutdown(srv)
 }
+
+// setupProxyAuth loads the forward proxy users and credentials
+func setupProxyAuth(log *zap.Logger) {
+	settings := proxyauth.Settings{
+		Realm:        proxyAuth.Realm,
+		HtpasswdFile: proxyAuth.HtpasswdFile,
+		Tokens:       map[string]string{},
+	}
+	for _, t := range proxyAuth.Tokens {
+		settings.Tokens[t.Token] = t.User
+	}
+	for _, u := range proxyAuth.Users {
+		settings.Users = append(settings.Users, proxyauth.UserSettings{
+			Name:         u.Name,
+			AllowedHosts: u.AllowedHosts,
+			RateLimit:    u.RateLimit,
+			Burst:        u.Burst,
+		})
+	}
+
+	auth, err := proxyauth.NewAuthenticator(settings)
+	if err != nil {
+		log.Fatal("Invalid forward_proxy auth", zap.Error(err))
+	}
+	proxy.SetForwardProxyAuth(auth)
+	log.Info("Forward proxy authentication enabled", zap.String("htpasswd", proxyAuth.HtpasswdFile), zap.Int("tokens", len(proxyAuth.Tokens)))
+}
+
+// setupCache creates the response cache, defaulting to 256MB in memory and 1MB entries
+func setupCache(log *zap.Logger) *cache.Cache {
+	opts := cache.Options{
+		MaxMemory:    256 << 20,
+		MaxEntrySize: 1 << 20,
+		DiskDir:      cacheSettings.DiskDir,
+		MaxDisk:      1 << 30,
+	}
+	if cacheSettings.MaxMemoryMB > 0 {
+		opts.MaxMemory = int64(cacheSettings.MaxMemoryMB) << 20
+	}
+	if cacheSettings.MaxEntryKB > 0 {
+		opts.MaxEntrySize = int64(cacheSettings.MaxEntryKB) << 10
+	}
+	if cacheSettings.MaxDiskMB > 0 {
+		opts.MaxDisk = int64(cacheSettings.MaxDiskMB) << 20
+	}
+	if d, err := time.ParseDuration(cacheSettings.DefaultTTL); err == nil {
+		opts.DefaultTTL = d
+	}
+
+	c, err := cache.New(opts)
+	if err != nil {
+		log.Fatal("Error opening cache", zap.String("disk_dir", opts.DiskDir), zap.Error(err))
+	}
+	log.Info("Response cache enabled", zap.Int64("max_memory", opts.MaxMemory), zap.String("disk_dir", opts.DiskDir))
+	return c
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/config"
+	"github.com/siddhu949/leanbalancer/internal/errorpages"
+	"github.com/siddhu949/leanbalancer/internal/headers"
+	"github.com/siddhu949/leanbalancer/internal/proxy"
+	"github.com/siddhu949/leanbalancer/internal/proxyproto"
+	"github.com/siddhu949/leanbalancer/internal/ratelimit"
+	"github.com/siddhu949/leanbalancer/internal/rewrite"
+	"github.com/siddhu949/leanbalancer/internal/tlsconfig"
+	"go.uber.org/zap"
+)
+
+var (
+	healthCheckTLS   config.BackendTLSConfig
+	poolConfigs      []config.PoolConfig
+	routeConfigs     []config.RouteConfig
+	errorPageConfigs map[int]config.ErrorPageConfig
+)
+
+// backendTLS builds the client TLS config for a pool, or nil if nothing is set
+func backendTLS(c config.BackendTLSConfig) (*tls.Config, error) {
+	if c == (config.BackendTLSConfig{}) {
+		return nil, nil
+	}
+	return tlsconfig.NewClientConfig(c.CAFile, c.CertFile, c.KeyFile, c.ServerName, c.InsecureSkipVerify)
+}
+
+// startPool builds a pool and starts its health checks
+func startPool(log *zap.Logger, pc config.PoolConfig) *proxy.Pool {
+	tlsCfg, err := backendTLS(pc.TLS)
+	if err != nil {
+		log.Fatal("Invalid backend TLS settings", zap.String("pool", pc.Name), zap.Error(err))
+	}
+
+	algorithmName := pc.Algorithm
+	if algorithmName == "" {
+		algorithmName = loadBalancerAlgorithm
+	}
+
+	p := proxy.NewPool(pc.Name, pc.Backends, algorithmName, healthCheckInterval, tlsCfg)
+	p.Timeout = timeout
+	if pc.Timeout != "" {
+		d, err := time.ParseDuration(pc.Timeout)
+		if err != nil || d <= 0 {
+			log.Fatal("Invalid pool timeout", zap.String("pool", pc.Name), zap.String("timeout", pc.Timeout))
+		}
+		p.Timeout = d
+	}
+	if err := p.SetProtocol(pc.Protocol); err != nil {
+		log.Fatal("Invalid pool protocol", zap.Error(err))
+	}
+	sendProxyProtocol, err := proxyproto.ParseVersion(pc.SendProxyProtocol)
+	if err == nil {
+		err = p.SetSendProxyProtocol(sendProxyProtocol)
+	}
+	if err != nil {
+		log.Fatal("Invalid pool send_proxy_protocol", zap.String("pool", pc.Name), zap.Error(err))
+	}
+	p.HealthChecker.Mode = pc.HealthCheck
+	p.HealthChecker.GRPCService = pc.GRPCHealthService
+
+	if healthCheckEnabled {
+		go p.HealthChecker.CheckHealth()
+		log.Info("Health checks enabled", zap.String("pool", pc.Name), zap.Int("backends", len(pc.Backends)))
+	}
+	return p
+}
+
+// headerRules compiles a route's header edits, or returns nil if there are none
+func headerRules(c config.HeaderRulesConfig) (*headers.Rules, error) {
+	if len(c.Add) == 0 && len(c.Set) == 0 && len(c.Remove) == 0 && len(c.Presets) == 0 {
+		return nil, nil
+	}
+	return headers.NewRules(c.Add, c.Set, c.Remove, c.Presets)
+}
+
+// rewriteRules compiles a route's rewrite rules, or returns nil if there are none
+func rewriteRules(rules []config.RewriteRuleConfig) (*rewrite.Rules, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	compiled := make([]rewrite.Rule, 0, len(rules))
+	for _, c := range rules {
+		compiled = append(compiled, rewrite.Rule{
+			Match:       c.Match,
+			Path:        c.Path,
+			Host:        c.Host,
+			Scheme:      c.Scheme,
+			AddQuery:    c.AddQuery,
+			RemoveQuery: c.RemoveQuery,
+			Redirect:    c.Redirect,
+		})
+	}
+	return rewrite.New(compiled)
+}
+
+// routeSplit builds a route's traffic split, or returns nil if it has none
+func routeSplit(c config.SplitConfig) (*proxy.Split, error) {
+	if len(c.Pools) == 0 {
+		return nil, nil
+	}
+	targets := make([]proxy.SplitTarget, 0, len(c.Pools))
+	for _, sp := range c.Pools {
+		p := proxy.GetPool(sp.Pool)
+		if p == nil {
+			return nil, fmt.Errorf("unknown pool %q", sp.Pool)
+		}
+		targets = append(targets, proxy.SplitTarget{Pool: p, Weight: sp.Weight})
+	}
+	return proxy.NewSplit(targets, c.Header, c.Cookie, c.Sticky)
+}
+
+// routeMirror builds a route's shadow pool mirror, or returns nil if it has none
+func routeMirror(c config.MirrorConfig) (*proxy.Mirror, error) {
+	if c.Pool == "" {
+		return nil, nil
+	}
+	p := proxy.GetPool(c.Pool)
+	if p == nil {
+		return nil, fmt.Errorf("unknown pool %q", c.Pool)
+	}
+	if c.Percent < 0 || c.Percent > 100 {
+		return nil, fmt.Errorf("percent must be between 0 and 100")
+	}
+	maxConcurrent := c.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = 100
+	}
+	timeout := 5 * time.Second
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("timeout: %w", err)
+		}
+		timeout = d
+	}
+	return proxy.NewMirror(p, c.Percent, maxConcurrent, timeout), nil
+}
+
+// routeHedge builds a route's hedging policy, or returns nil if it has none
+func routeHedge(c config.HedgeConfig) (*proxy.Hedge, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	delay, percentile := 100*time.Millisecond, 0.0
+	if p, ok := strings.CutPrefix(c.Delay, "p"); ok {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v <= 0 || v >= 100 {
+			return nil, fmt.Errorf("delay: invalid percentile %q", c.Delay)
+		}
+		percentile = v
+	} else if c.Delay != "" {
+		d, err := time.ParseDuration(c.Delay)
+		if err != nil {
+			return nil, fmt.Errorf("delay: %w", err)
+		}
+		delay = d
+	}
+	budget := c.Budget
+	if budget == 0 {
+		budget = 10
+	}
+	if budget < 0 || budget > 100 {
+		return nil, fmt.Errorf("budget must be between 0 and 100")
+	}
+	return proxy.NewHedge(delay, percentile, budget), nil
+}
+
+// rateLimit builds a limiter, or returns nil if it has none
+func rateLimit(c config.RateLimitConfig) (*ratelimit.Limiter, error) {
+	if c.Requests == 0 {
+		return nil, nil
+	}
+	if c.Requests < 0 || c.Burst < 0 || c.MaxKeys < 0 {
+		return nil, fmt.Errorf("requests, burst and max_keys must be positive")
+	}
+	period := time.Minute
+	if c.Period != "" {
+		d, err := time.ParseDuration(c.Period)
+		if err != nil {
+			return nil, fmt.Errorf("period: %w", err)
+		}
+		period = d
+	}
+	if period <= 0 {
+		return nil, fmt.Errorf("period must be positive")
+	}
+	return ratelimit.NewLimiter(c.Requests, period, c.Burst, c.MaxKeys), nil
+}
+
+// errorPages loads error page files, or returns nil if there are none
+func errorPages(c map[int]config.ErrorPageConfig) (*errorpages.Pages, error) {
+	if len(c) == 0 {
+		return nil, nil
+	}
+	files := make(map[int]errorpages.Files, len(c))
+	for status, pc := range c {
+		files[status] = errorpages.Files{HTML: pc.HTML, JSON: pc.JSON}
+	}
+	return errorpages.Load(files)
+}
+
+// setupPools builds the default pool, the configured pools and their routes
+func setupPools(log *zap.Logger) {
+	defaultPages, err := errorPages(errorPageConfigs)
+	if err != nil {
+		log.Fatal("Invalid error_pages", zap.Error(err))
+	}
+	errorpages.SetDefaults(defaultPages)
+
+	proxy.SetDefaultPool(startPool(log, config.PoolConfig{
+		Name:     proxy.DefaultPoolName,
+		Backends: healthCheckBackends,
+		TLS:      healthCheckTLS,
+	}))
+
+	for _, pc := range poolConfigs {
+		proxy.RegisterPool(startPool(log, pc))
+	}
+
+	for _, rc := range routeConfigs {
+		p := proxy.GetPool(rc.Pool)
+		if p == nil && rc.Pool != "" {
+			log.Fatal("Invalid route", zap.String("path_prefix", rc.PathPrefix), zap.String("unknown_pool", rc.Pool))
+		}
+		split, err := routeSplit(rc.Split)
+		if err != nil {
+			log.Fatal("Invalid route split", zap.String("path_prefix", rc.PathPrefix), zap.Error(err))
+		}
+		mirror, err := routeMirror(rc.Mirror)
+		if err != nil {
+			log.Fatal("Invalid route mirror", zap.String("path_prefix", rc.PathPrefix), zap.Error(err))
+		}
+		hedge, err := routeHedge(rc.Hedge)
+		if err != nil {
+			log.Fatal("Invalid route hedge", zap.String("path_prefix", rc.PathPrefix), zap.Error(err))
+		}
+		limiter, err := rateLimit(rc.RateLimit)
+		if err != nil {
+			log.Fatal("Invalid route rate_limit", zap.String("path_prefix", rc.PathPrefix), zap.Error(err))
+		}
+		limitKey, err := ratelimit.ParseKey(rc.RateLimit.Key)
+		if err != nil {
+			log.Fatal("Invalid route rate_limit", zap.String("path_prefix", rc.PathPrefix), zap.Error(err))
+		}
+		pages, err := errorPages(rc.ErrorPages)
+		if err != nil {
+			log.Fatal("Invalid route error_pages", zap.String("path_prefix", rc.PathPrefix), zap.Error(err))
+		}
+		rewrites, err := rewriteRules(rc.Rewrite)
+		if err != nil {
+			log.Fatal("Invalid route rewrite", zap.String("path_prefix", rc.PathPrefix), zap.Error(err))
+		}
+		requestHeaders, err := headerRules(rc.RequestHeaders)
+		if err != nil {
+			log.Fatal("Invalid route request_headers", zap.String("path_prefix", rc.PathPrefix), zap.Error(err))
+		}
+		responseHeaders, err := headerRules(rc.ResponseHeaders)
+		if err != nil {
+			log.Fatal("Invalid route response_headers", zap.String("path_prefix", rc.PathPrefix), zap.Error(err))
+		}
+
+		route := &proxy.Route{
+			PathPrefix:      rc.PathPrefix,
+			Type:            rc.Type,
+			Pool:            p,
+			Split:           split,
+			Mirror:          mirror,
+			Hedge:           hedge,
+			MaxBodySize:     rc.MaxBodySize,
+			RateLimit:       limiter,
+			RateLimitKey:    limitKey,
+			ErrorPages:      pages,
+			Cache:           rc.Cache,
+			Rewrite:         rewrites,
+			RequestHeaders:  requestHeaders,
+			ResponseHeaders: responseHeaders,
+		}
+		if err := proxy.AddRoute(route); err != nil {
+			log.Fatal("Invalid route", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package admin
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/siddhu949/leanbalancer/internal/cache"
+)
+
+// RegisterCacheRoutes registers the response cache stats and purge endpoints.
+// Keys are the request host, path and query, e.g. "shop.example.com/api/items?page=2".
+func RegisterCacheRoutes(app *fiber.App, c *cache.Cache) {
+	app.Get("/admin/cache", func(ctx *fiber.Ctx) error {
+		return ctx.JSON(c.Stats())
+	})
+
+	// DELETE /admin/cache?key=... or ?prefix=...
+	app.Delete("/admin/cache", func(ctx *fiber.Ctx) error {
+		if key := ctx.Query("key"); key != "" {
+			return ctx.JSON(fiber.Map{"purged": c.Purge(key)})
+		}
+		if prefix := ctx.Query("prefix"); prefix != "" {
+			return ctx.JSON(fiber.Map{"purged": c.PurgePrefix(prefix)})
+		}
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "key or prefix is required"})
+	})
+}
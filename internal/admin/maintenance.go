@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/siddhu949/leanbalancer/internal/proxy"
+)
+
+// maintenanceRequest is the body of PUT /admin/maintenance
+type maintenanceRequest struct {
+	Enabled    bool   `json:"enabled"`
+	RetryAfter string `json:"retry_after"` // Duration, e.g. "10m"
+}
+
+// RegisterMaintenanceRoutes registers the endpoints that show and toggle
+// maintenance mode, in which a route answers 503 with Retry-After
+func RegisterMaintenanceRoutes(app *fiber.App) {
+	app.Get("/admin/maintenance", func(ctx *fiber.Ctx) error {
+		status := fiber.Map{}
+		for _, r := range proxy.Routes() {
+			enabled, retryAfter := r.Maintenance()
+			status[r.PathPrefix] = fiber.Map{"enabled": enabled, "retry_after": retryAfter.String()}
+		}
+		return ctx.JSON(status)
+	})
+
+	// PUT /admin/maintenance?route=/api/ with {"enabled": true, "retry_after": "10m"}
+	app.Put("/admin/maintenance", func(ctx *fiber.Ctx) error {
+		r := proxy.GetRoute(ctx.Query("route"))
+		if r == nil {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "no route " + ctx.Query("route")})
+		}
+		var req maintenanceRequest
+		if err := ctx.BodyParser(&req); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		var retryAfter time.Duration
+		if req.RetryAfter != "" {
+			d, err := time.ParseDuration(req.RetryAfter)
+			if err != nil {
+				return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "retry_after: " + err.Error()})
+			}
+			retryAfter = d
+		}
+		r.SetMaintenance(req.Enabled, retryAfter)
+		return ctx.JSON(fiber.Map{"enabled": req.Enabled, "retry_after": retryAfter.String()})
+	})
+}
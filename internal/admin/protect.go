@@ -0,0 +1,32 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Protect guards the /admin endpoints that change state: with a token they
+// need "Authorization: Bearer <token>", and without one they only answer
+// clients on localhost. Reads stay open for dashboards and scrapers. It must
+// be called before the routes are registered.
+func Protect(app *fiber.App, token string) {
+	app.Use("/admin", func(ctx *fiber.Ctx) error {
+		if ctx.Method() == fiber.MethodGet || ctx.Method() == fiber.MethodHead {
+			return ctx.Next()
+		}
+		if token != "" {
+			want := []byte("Bearer " + token)
+			if subtle.ConstantTimeCompare([]byte(ctx.Get(fiber.HeaderAuthorization)), want) == 1 {
+				return ctx.Next()
+			}
+			ctx.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="admin"`)
+			return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "admin token required"})
+		}
+		if ip := net.ParseIP(ctx.IP()); ip != nil && ip.IsLoopback() {
+			return ctx.Next()
+		}
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "admin changes are only accepted from localhost unless server.admin_token is set"})
+	})
+}
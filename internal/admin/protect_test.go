@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// protectedApp has one read and one change endpoint behind Protect
+func protectedApp(token string) *fiber.App {
+	app := fiber.New()
+	Protect(app, token)
+	app.Get("/admin/thing", func(ctx *fiber.Ctx) error { return ctx.SendString("read") })
+	app.Put("/admin/thing", func(ctx *fiber.Ctx) error { return ctx.SendString("changed") })
+	return app
+}
+
+func TestProtectWithToken(t *testing.T) {
+	app := protectedApp("s3cret")
+	for _, tt := range []struct {
+		method, auth string
+		want         int
+	}{
+		{http.MethodGet, "", http.StatusOK},
+		{http.MethodPut, "", http.StatusUnauthorized},
+		{http.MethodPut, "Bearer wrong", http.StatusUnauthorized},
+		{http.MethodPut, "Bearer s3cret", http.StatusOK},
+	} {
+		req := httptest.NewRequest(tt.method, "/admin/thing", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s with %q: status %d, want %d", tt.method, tt.auth, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestProtectWithoutTokenAllowsOnlyLocalhost(t *testing.T) {
+	app := protectedApp("")
+
+	// app.Test connects from 0.0.0.0, which is not loopback
+	resp, err := app.Test(httptest.NewRequest(http.MethodPut, "/admin/thing", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("remote change: status %d, want 403", resp.StatusCode)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+	req, _ := http.NewRequest(http.MethodPut, "http://"+ln.Addr().String()+"/admin/thing", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("local change: status %d, want 200", resp.StatusCode)
+	}
+}
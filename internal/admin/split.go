@@ -0,0 +1,34 @@
+package admin
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/siddhu949/leanbalancer/internal/proxy"
+)
+
+// RegisterSplitRoutes registers the endpoints that show and change the
+// weights of routes with traffic splits
+func RegisterSplitRoutes(app *fiber.App) {
+	app.Get("/admin/splits", func(ctx *fiber.Ctx) error {
+		weights := fiber.Map{}
+		for prefix, s := range proxy.RouteSplits() {
+			weights[prefix] = s.Weights()
+		}
+		return ctx.JSON(weights)
+	})
+
+	// PUT /admin/splits?route=/app/ with {"stable": 90, "canary": 10}
+	app.Put("/admin/splits", func(ctx *fiber.Ctx) error {
+		s := proxy.RouteSplits()[ctx.Query("route")]
+		if s == nil {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "no split on route " + ctx.Query("route")})
+		}
+		var weights map[string]int
+		if err := ctx.BodyParser(&weights); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := s.SetWeights(weights); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return ctx.JSON(s.Weights())
+	})
+}
@@ -0,0 +1,277 @@
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/metrics"
+	"github.com/valyala/fasthttp"
+)
+
+// Options configures a Cache
+type Options struct {
+	MaxMemory    int64         // Bytes held in memory
+	MaxEntrySize int64         // Larger responses are not stored
+	DiskDir      string        // Optional second tier for entries evicted from memory
+	MaxDisk      int64         // Bytes held on disk
+	DefaultTTL   time.Duration // Freshness for responses without Cache-Control or Expires; 0 stores only those with validators
+}
+
+// Cache is a size-bounded LRU of responses with an optional disk tier
+type Cache struct {
+	opts Options
+
+	mu    sync.Mutex
+	bytes int64
+	ll    *list.List // Most recently used first
+	items map[string]*list.Element
+
+	disk *diskStore
+	// Disk writes wait in pending, keyed by entry, for the spill goroutine;
+	// a nil entry deletes the outdated disk copy. writing is the batch being
+	// written, still served from memory until it is on disk. spillMu is held
+	// while a batch is written, so a purge cannot be undone by it.
+	pending map[string]*Entry
+	writing map[string]*Entry
+	wake    chan struct{}
+	spillMu sync.Mutex
+}
+
+// maxPendingSpills bounds the evicted entries waiting to be written to disk;
+// beyond it they are dropped rather than spilled
+const maxPendingSpills = 1024
+
+// New creates a cache, loading any entries already in opts.DiskDir
+func New(opts Options) (*Cache, error) {
+	c := &Cache{opts: opts, ll: list.New(), items: map[string]*list.Element{}}
+	if opts.DiskDir != "" {
+		disk, err := newDiskStore(opts.DiskDir, opts.MaxDisk)
+		if err != nil {
+			return nil, err
+		}
+		c.disk = disk
+		c.pending = map[string]*Entry{}
+		c.wake = make(chan struct{}, 1)
+		metrics.CacheBytes.WithLabelValues("disk").Set(float64(disk.size()))
+		go c.spill()
+	}
+	return c, nil
+}
+
+// Get returns the entry for key matching the request's Vary headers, or nil
+func (c *Cache) Get(key string, req *fasthttp.RequestHeader) *Entry {
+	e := c.lookup(key)
+	if e != nil && e.Status == 0 {
+		e = c.lookup(variantKey(key, e.Vary, req))
+	}
+	return e
+}
+
+// NewEntry builds an entry from an upstream response, or returns false if it
+// may not be stored
+func (c *Cache) NewEntry(key string, resp *fasthttp.Response, now time.Time) (*Entry, bool) {
+	if c.opts.MaxEntrySize > 0 && int64(len(resp.Body())) > c.opts.MaxEntrySize {
+		return nil, false
+	}
+	return newEntry(key, resp.StatusCode(), &resp.Header, resp.Body(), now, c.opts.DefaultTTL)
+}
+
+// Revalidated returns a copy of e refreshed by a 304 Not Modified response
+func (c *Cache) Revalidated(e *Entry, notModified *fasthttp.ResponseHeader, now time.Time) (*Entry, bool) {
+	var h fasthttp.ResponseHeader
+	for _, kv := range e.Header {
+		h.Add(kv[0], kv[1])
+	}
+	notModified.VisitAll(func(k, v []byte) {
+		if !skipHeaders[string(k)] && string(k) != fasthttp.HeaderContentType {
+			h.SetBytesKV(k, v)
+		}
+	})
+	return newEntry(e.Key, e.Status, &h, e.Body, now, c.opts.DefaultTTL)
+}
+
+// Set stores e for the request, recording its Vary headers under key
+func (c *Cache) Set(key string, req *fasthttp.RequestHeader, e *Entry) {
+	if len(e.Vary) == 0 {
+		c.store(key, e)
+		return
+	}
+	c.store(key, &Entry{Key: key, Vary: e.Vary, Stored: e.Stored})
+	variant := *e
+	variant.Key = variantKey(key, e.Vary, req)
+	c.store(variant.Key, &variant)
+}
+
+// variantKey extends key with the values of the Vary request headers
+func variantKey(key string, vary []string, req *fasthttp.RequestHeader) string {
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range vary {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteString("=")
+		b.Write(req.Peek(name))
+	}
+	return b.String()
+}
+
+func (c *Cache) lookup(key string) *Entry {
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*Entry)
+	}
+	if c.disk == nil {
+		c.mu.Unlock()
+		return nil
+	}
+	// Entries on their way to disk; a pending delete means the disk copy is outdated
+	e, queued := c.pending[key]
+	if !queued {
+		e, queued = c.writing[key]
+	}
+	c.mu.Unlock()
+
+	if !queued {
+		e = c.disk.get(key)
+	}
+	if e != nil {
+		c.store(key, e) // Promote to memory
+	}
+	return e
+}
+
+func (c *Cache) store(key string, e *Entry) {
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		c.bytes -= el.Value.(*Entry).size()
+		c.ll.Remove(el)
+	}
+	c.items[key] = c.ll.PushFront(e)
+	c.bytes += e.size()
+
+	var evicted []*Entry
+	for c.bytes > c.opts.MaxMemory && c.ll.Len() > 1 {
+		el := c.ll.Back()
+		old := el.Value.(*Entry)
+		c.ll.Remove(el)
+		delete(c.items, old.Key)
+		c.bytes -= old.size()
+		evicted = append(evicted, old)
+	}
+	metrics.CacheBytes.WithLabelValues("memory").Set(float64(c.bytes))
+
+	if c.disk == nil {
+		c.mu.Unlock()
+		return
+	}
+	// The disk copy of a replaced entry is outdated; evicted entries spill to
+	// disk. Both are left to the spill goroutine, off the request path.
+	c.pending[key] = nil
+	for _, old := range evicted {
+		if len(c.pending) < maxPendingSpills {
+			c.pending[old.Key] = old
+		}
+	}
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default: // Already woken
+	}
+}
+
+// spill writes pending entries to disk and deletes outdated disk copies
+func (c *Cache) spill() {
+	for range c.wake {
+		c.spillMu.Lock()
+		c.mu.Lock()
+		batch := c.pending
+		c.pending = map[string]*Entry{}
+		c.writing = batch
+		c.mu.Unlock()
+
+		for key, e := range batch {
+			if e == nil {
+				c.disk.delete(key)
+			} else {
+				c.disk.set(e)
+			}
+		}
+		metrics.CacheBytes.WithLabelValues("disk").Set(float64(c.disk.size()))
+
+		c.mu.Lock()
+		c.writing = nil
+		c.mu.Unlock()
+		c.spillMu.Unlock()
+	}
+}
+
+// Purge removes key and all its Vary variants, returning the number of entries removed
+func (c *Cache) Purge(key string) int {
+	return c.remove(func(k string) bool {
+		return k == key || strings.HasPrefix(k, key+"\x00")
+	})
+}
+
+// PurgePrefix removes every entry whose key starts with prefix
+func (c *Cache) PurgePrefix(prefix string) int {
+	return c.remove(func(k string) bool {
+		return strings.HasPrefix(k, prefix)
+	})
+}
+
+func (c *Cache) remove(match func(string) bool) int {
+	removed := 0
+	if c.disk != nil {
+		// Wait for a batch being written, so it cannot restore purged entries
+		c.spillMu.Lock()
+		defer c.spillMu.Unlock()
+	}
+	c.mu.Lock()
+	for key, el := range c.items {
+		if match(key) {
+			c.bytes -= el.Value.(*Entry).size()
+			c.ll.Remove(el)
+			delete(c.items, key)
+			removed++
+		}
+	}
+	for key, e := range c.pending {
+		if match(key) {
+			delete(c.pending, key)
+			if e != nil {
+				removed++
+			}
+		}
+	}
+	metrics.CacheBytes.WithLabelValues("memory").Set(float64(c.bytes))
+	c.mu.Unlock()
+
+	if c.disk != nil {
+		removed += c.disk.remove(match)
+		metrics.CacheBytes.WithLabelValues("disk").Set(float64(c.disk.size()))
+	}
+	return removed
+}
+
+// Stats describes the cache contents
+type Stats struct {
+	MemoryEntries int   `json:"memory_entries"`
+	MemoryBytes   int64 `json:"memory_bytes"`
+	DiskEntries   int   `json:"disk_entries"`
+	DiskBytes     int64 `json:"disk_bytes"`
+}
+
+// Stats returns the current entry counts and sizes
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	s := Stats{MemoryEntries: c.ll.Len(), MemoryBytes: c.bytes}
+	c.mu.Unlock()
+	if c.disk != nil {
+		s.DiskEntries, s.DiskBytes = c.disk.len(), c.disk.size()
+	}
+	return s
+}
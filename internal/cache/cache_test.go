@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func response(t *testing.T, c *Cache, key, body string, headers map[string]string) *Entry {
+	t.Helper()
+	var resp fasthttp.Response
+	for k, v := range headers {
+		resp.Header.Set(k, v)
+	}
+	resp.SetBodyString(body)
+	e, ok := c.NewEntry(key, &resp, time.Now())
+	if !ok {
+		t.Fatalf("%s was not storable", key)
+	}
+	return e
+}
+
+func request(headers map[string]string) *fasthttp.RequestHeader {
+	var h fasthttp.RequestHeader
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	return &h
+}
+
+func TestSetGetPurgeWithVary(t *testing.T) {
+	c, err := New(Options{MaxMemory: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vary := map[string]string{"Cache-Control": "max-age=60", "Vary": "Accept-Language"}
+	en, fr := request(map[string]string{"Accept-Language": "en"}), request(map[string]string{"Accept-Language": "fr"})
+	c.Set("GET /a", en, response(t, c, "GET /a", "hello", vary))
+	c.Set("GET /a", fr, response(t, c, "GET /a", "bonjour", vary))
+	c.Set("GET /ab", en, response(t, c, "GET /ab", "other", map[string]string{"Cache-Control": "max-age=60"}))
+
+	for _, tt := range []struct {
+		req  *fasthttp.RequestHeader
+		want string
+	}{{en, "hello"}, {fr, "bonjour"}} {
+		if e := c.Get("GET /a", tt.req); e == nil || string(e.Body) != tt.want {
+			t.Fatalf("Accept-Language %s: got %v, want %q", tt.req.Peek("Accept-Language"), e, tt.want)
+		}
+	}
+	if e := c.Get("GET /a", request(map[string]string{"Accept-Language": "de"})); e != nil {
+		t.Fatalf("unknown variant answered %q", e.Body)
+	}
+
+	// The Vary stub and both variants go; a key it prefixes stays
+	if n := c.Purge("GET /a"); n != 3 {
+		t.Errorf("purged %d entries, want 3", n)
+	}
+	if e := c.Get("GET /a", en); e != nil {
+		t.Error("purged entry is still served")
+	}
+	if e := c.Get("GET /ab", en); e == nil {
+		t.Error("purge removed a key it only prefixes")
+	}
+}
+
+func TestEvictedEntriesSpillToDisk(t *testing.T) {
+	ttl := map[string]string{"Cache-Control": "max-age=60"}
+	c, err := New(Options{MaxMemory: 1, DiskDir: t.TempDir(), MaxDisk: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := request(nil)
+	c.Set("a", req, response(t, c, "a", "first", ttl))
+	c.Set("b", req, response(t, c, "b", "second", ttl)) // Evicts a from memory
+
+	// Served while it waits to be written, then from disk
+	if e := c.Get("a", req); e == nil || string(e.Body) != "first" {
+		t.Fatalf("evicted entry: got %v", e)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Stats().DiskEntries == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.Stats().DiskEntries == 0 {
+		t.Fatal("nothing was spilled to disk")
+	}
+
+	c.Purge("a")
+	c.Purge("b")
+	if s := c.Stats(); s.MemoryEntries != 0 || s.DiskEntries != 0 {
+		t.Errorf("after purging: %+v", s)
+	}
+	if e := c.Get("a", req); e != nil {
+		t.Error("purged entry came back from disk")
+	}
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const diskSuffix = ".entry"
+
+// diskStore keeps gob-encoded entries in a directory, one file per key,
+// with an in-memory LRU index bounded by maxBytes
+type diskStore struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	bytes int64
+	ll    *list.List // Of *diskItem, most recently used first
+	items map[string]*list.Element
+}
+
+type diskItem struct {
+	key  string
+	size int64
+}
+
+// newDiskStore opens dir, indexing the entries left by a previous run
+func newDiskStore(dir string, maxBytes int64) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	d := &diskStore{dir: dir, maxBytes: maxBytes, ll: list.New(), items: map[string]*list.Element{}}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), diskSuffix) {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		e, size := readEntry(path)
+		if e == nil {
+			os.Remove(path)
+			continue
+		}
+		d.items[e.Key] = d.ll.PushBack(&diskItem{key: e.Key, size: size})
+		d.bytes += size
+	}
+	return d, nil
+}
+
+func (d *diskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+diskSuffix)
+}
+
+func readEntry(path string) (*Entry, int64) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0
+	}
+	var e Entry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&e); err != nil {
+		return nil, 0
+	}
+	return &e, int64(len(data))
+}
+
+func (d *diskStore) get(key string) *Entry {
+	d.mu.Lock()
+	el, ok := d.items[key]
+	if ok {
+		d.ll.MoveToFront(el)
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	e, _ := readEntry(d.path(key))
+	if e == nil || e.Key != key {
+		d.delete(key)
+		return nil
+	}
+	return e
+}
+
+func (d *diskStore) set(e *Entry) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return
+	}
+	size := int64(buf.Len())
+	if size > d.maxBytes {
+		return
+	}
+
+	// Write then rename so readers never see a partial file
+	path := d.path(e.Key)
+	tmp, err := os.CreateTemp(d.dir, "tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if cerr := tmp.Close(); err != nil || cerr != nil || os.Rename(tmp.Name(), path) != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.items[e.Key]; ok {
+		d.bytes -= el.Value.(*diskItem).size
+		d.ll.Remove(el)
+	}
+	d.items[e.Key] = d.ll.PushFront(&diskItem{key: e.Key, size: size})
+	d.bytes += size
+
+	for d.bytes > d.maxBytes && d.ll.Len() > 1 {
+		d.removeLocked(d.ll.Back())
+	}
+}
+
+func (d *diskStore) removeLocked(el *list.Element) {
+	item := el.Value.(*diskItem)
+	d.ll.Remove(el)
+	delete(d.items, item.key)
+	d.bytes -= item.size
+	os.Remove(d.path(item.key))
+}
+
+func (d *diskStore) delete(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.items[key]; ok {
+		d.removeLocked(el)
+	}
+}
+
+func (d *diskStore) remove(match func(string) bool) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	removed := 0
+	for key, el := range d.items {
+		if match(key) {
+			d.removeLocked(el)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (d *diskStore) size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.bytes
+}
+
+func (d *diskStore) len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ll.Len()
+}
@@ -0,0 +1,216 @@
+package cache
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Entry is a stored response
+type Entry struct {
+	Key    string
+	Status int // 0 on a Vary stub, which only records the Vary headers for Key
+	Header [][2]string
+	Body   []byte
+	Vary   []string // Request headers (lower case) that select the variant
+
+	Stored               time.Time     // Received or last revalidated
+	Lifetime             time.Duration // Fresh until Stored + Lifetime
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	MustRevalidate       bool
+
+	ETag         string
+	LastModified string
+}
+
+// Statuses that may be stored (RFC 9110 heuristically cacheable, plus 308)
+var cacheableStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true,
+	308: true, 404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// Headers that are never stored; Age and Content-Length are recomputed
+var skipHeaders = map[string]bool{
+	"Connection": true, "Keep-Alive": true, "Proxy-Connection": true,
+	"Transfer-Encoding": true, "Upgrade": true, "Te": true, "Trailer": true,
+	"Content-Length": true, "Age": true, "Set-Cookie": true, "X-Cache": true,
+}
+
+// parseCacheControl splits a Cache-Control header into lower-case directives
+func parseCacheControl(value []byte) map[string]string {
+	directives := map[string]string{}
+	for _, part := range strings.Split(string(value), ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+func seconds(directives map[string]string, name string) (time.Duration, bool) {
+	arg, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 0 {
+		return 0, true // Invalid values count as already stale
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// RequestNoCache reports whether the client asked for a revalidated response
+func RequestNoCache(h *fasthttp.RequestHeader) bool {
+	cc := parseCacheControl(h.Peek(fasthttp.HeaderCacheControl))
+	if _, ok := cc["no-cache"]; ok {
+		return true
+	}
+	if maxAge, ok := seconds(cc, "max-age"); ok && maxAge == 0 {
+		return true
+	}
+	return bytes.Contains(h.Peek(fasthttp.HeaderPragma), []byte("no-cache"))
+}
+
+// RequestNoStore reports whether the client asked not to use the cache at all
+func RequestNoStore(h *fasthttp.RequestHeader) bool {
+	_, ok := parseCacheControl(h.Peek(fasthttp.HeaderCacheControl))["no-store"]
+	return ok
+}
+
+// newEntry builds an entry from a response, or returns false if it may not be stored.
+// defaultTTL is used when the response has no explicit freshness.
+func newEntry(key string, status int, h *fasthttp.ResponseHeader, body []byte, now time.Time, defaultTTL time.Duration) (*Entry, bool) {
+	if !cacheableStatus[status] {
+		return nil, false
+	}
+	cc := parseCacheControl(h.Peek(fasthttp.HeaderCacheControl))
+	if _, ok := cc["no-store"]; ok {
+		return nil, false
+	}
+	if _, ok := cc["private"]; ok {
+		return nil, false
+	}
+	hasCookie := false
+	h.VisitAllCookie(func(_, _ []byte) { hasCookie = true })
+	if hasCookie {
+		return nil, false
+	}
+
+	e := &Entry{
+		Key:          key,
+		Status:       status,
+		Body:         body,
+		Stored:       now,
+		ETag:         string(h.Peek(fasthttp.HeaderETag)),
+		LastModified: string(h.Peek(fasthttp.HeaderLastModified)),
+	}
+	for _, name := range strings.Split(string(h.Peek(fasthttp.HeaderVary)), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name == "*" {
+			return nil, false
+		} else if name != "" {
+			e.Vary = append(e.Vary, name)
+		}
+	}
+
+	// Shared caches prefer s-maxage, then max-age, then Expires
+	if d, ok := seconds(cc, "s-maxage"); ok {
+		e.Lifetime = d
+	} else if d, ok := seconds(cc, "max-age"); ok {
+		e.Lifetime = d
+	} else if expires := h.Peek(fasthttp.HeaderExpires); len(expires) > 0 {
+		date := now
+		if t, err := fasthttp.ParseHTTPDate(h.Peek(fasthttp.HeaderDate)); err == nil {
+			date = t
+		}
+		if t, err := fasthttp.ParseHTTPDate(expires); err == nil && t.After(date) {
+			e.Lifetime = t.Sub(date)
+		}
+	} else {
+		e.Lifetime = defaultTTL
+	}
+	if _, ok := cc["no-cache"]; ok {
+		e.Lifetime = 0
+	}
+	if age, err := strconv.Atoi(string(h.Peek(fasthttp.HeaderAge))); err == nil && age > 0 {
+		e.Lifetime -= time.Duration(age) * time.Second
+	}
+
+	_, mustRevalidate := cc["must-revalidate"]
+	_, proxyRevalidate := cc["proxy-revalidate"]
+	e.MustRevalidate = mustRevalidate || proxyRevalidate
+	e.StaleWhileRevalidate, _ = seconds(cc, "stale-while-revalidate")
+	e.StaleIfError, _ = seconds(cc, "stale-if-error")
+
+	// Nothing to serve later without freshness, a stale window or validators
+	if e.Lifetime <= 0 && e.StaleWhileRevalidate == 0 && e.StaleIfError == 0 &&
+		e.ETag == "" && e.LastModified == "" {
+		return nil, false
+	}
+
+	h.VisitAll(func(k, v []byte) {
+		if !skipHeaders[string(k)] {
+			e.Header = append(e.Header, [2]string{string(k), string(v)})
+		}
+	})
+	return e, true
+}
+
+// Fresh reports whether the entry can be served without revalidation
+func (e *Entry) Fresh(now time.Time) bool {
+	return now.Before(e.Stored.Add(e.Lifetime))
+}
+
+// Stale reports whether the entry may still be served within a stale
+// window (stale-while-revalidate or stale-if-error) past its freshness
+func (e *Entry) Stale(now time.Time, window time.Duration) bool {
+	return !e.MustRevalidate && window > 0 && now.Before(e.Stored.Add(e.Lifetime+window))
+}
+
+// WriteTo writes the stored response to resp, with its current Age
+func (e *Entry) WriteTo(resp *fasthttp.Response, now time.Time) {
+	resp.SetStatusCode(e.Status)
+	for _, kv := range e.Header {
+		resp.Header.Add(kv[0], kv[1])
+	}
+	resp.Header.Set(fasthttp.HeaderAge, strconv.Itoa(int(now.Sub(e.Stored).Seconds())))
+	resp.SetBody(e.Body)
+}
+
+// NotModified reports whether a conditional request matches the entry's validators
+func (e *Entry) NotModified(h *fasthttp.RequestHeader) bool {
+	if inm := h.Peek(fasthttp.HeaderIfNoneMatch); len(inm) > 0 {
+		if e.ETag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(string(inm), ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(e.ETag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := fasthttp.ParseHTTPDate(h.Peek(fasthttp.HeaderIfModifiedSince)); err == nil && e.LastModified != "" {
+		if lm, err := fasthttp.ParseHTTPDate([]byte(e.LastModified)); err == nil {
+			return !lm.After(ims)
+		}
+	}
+	return false
+}
+
+// size approximates the memory held by the entry
+func (e *Entry) size() int64 {
+	n := len(e.Key) + len(e.Body) + len(e.ETag) + len(e.LastModified) + 128
+	for _, kv := range e.Header {
+		n += len(kv[0]) + len(kv[1])
+	}
+	for _, v := range e.Vary {
+		n += len(v)
+	}
+	return int64(n)
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var testNow = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestNewEntry(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		status     int
+		headers    map[string]string
+		defaultTTL time.Duration
+		stored     bool
+		lifetime   time.Duration
+		vary       []string
+	}{
+		{"max-age", 200, map[string]string{"Cache-Control": "max-age=60"}, 0, true, time.Minute, nil},
+		{"s-maxage wins", 200, map[string]string{"Cache-Control": "max-age=60, s-maxage=120"}, 0, true, 2 * time.Minute, nil},
+		{"max-age wins over Expires", 200, map[string]string{"Cache-Control": "max-age=60", "Expires": "Wed, 01 May 2024 13:00:00 GMT"}, 0, true, time.Minute, nil},
+		{"Expires", 200, map[string]string{"Expires": "Wed, 01 May 2024 12:10:00 GMT"}, 0, true, 10 * time.Minute, nil},
+		{"Expires in the past", 200, map[string]string{"Expires": "Wed, 01 May 2024 11:00:00 GMT", "ETag": `"v1"`}, time.Hour, true, 0, nil},
+		{"default TTL", 200, nil, time.Hour, true, time.Hour, nil},
+		{"no freshness or validators", 200, nil, 0, false, 0, nil},
+		{"validators only", 200, map[string]string{"ETag": `"v1"`}, 0, true, 0, nil},
+		{"no-cache keeps validators", 200, map[string]string{"Cache-Control": "no-cache, max-age=60", "Last-Modified": "Wed, 01 May 2024 10:00:00 GMT"}, 0, true, 0, nil},
+		{"no-cache without validators", 200, map[string]string{"Cache-Control": "no-cache, max-age=60"}, 0, false, 0, nil},
+		{"Age is subtracted", 200, map[string]string{"Cache-Control": "max-age=60", "Age": "20"}, 0, true, 40 * time.Second, nil},
+		{"invalid max-age is stale", 200, map[string]string{"Cache-Control": "max-age=soon", "ETag": `"v1"`}, time.Hour, true, 0, nil},
+		{"stale window only", 200, map[string]string{"Cache-Control": "max-age=0, stale-if-error=60"}, 0, true, 0, nil},
+		{"no-store", 200, map[string]string{"Cache-Control": "no-store, max-age=60"}, 0, false, 0, nil},
+		{"private", 200, map[string]string{"Cache-Control": "private, max-age=60"}, 0, false, 0, nil},
+		{"Set-Cookie", 200, map[string]string{"Cache-Control": "max-age=60", "Set-Cookie": "id=1"}, 0, false, 0, nil},
+		{"uncacheable status", 500, map[string]string{"Cache-Control": "max-age=60"}, 0, false, 0, nil},
+		{"cacheable 404", 404, map[string]string{"Cache-Control": "max-age=60"}, 0, true, time.Minute, nil},
+		{"Vary", 200, map[string]string{"Cache-Control": "max-age=60", "Vary": "Accept-Encoding, Accept-Language"}, 0, true, time.Minute, []string{"accept-encoding", "accept-language"}},
+		{"Vary *", 200, map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}, 0, false, 0, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var h fasthttp.ResponseHeader
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			e, ok := newEntry("k", tt.status, &h, []byte("body"), testNow, tt.defaultTTL)
+			if ok != tt.stored {
+				t.Fatalf("stored %v, want %v", ok, tt.stored)
+			}
+			if !ok {
+				return
+			}
+			if e.Lifetime != tt.lifetime {
+				t.Errorf("lifetime %v, want %v", e.Lifetime, tt.lifetime)
+			}
+			if len(e.Vary) != len(tt.vary) {
+				t.Fatalf("vary %q, want %q", e.Vary, tt.vary)
+			}
+			for i := range tt.vary {
+				if e.Vary[i] != tt.vary[i] {
+					t.Errorf("vary %q, want %q", e.Vary, tt.vary)
+				}
+			}
+			for _, kv := range e.Header {
+				if skipHeaders[kv[0]] {
+					t.Errorf("stored header %s", kv[0])
+				}
+			}
+		})
+	}
+}
+
+func TestFreshAndStale(t *testing.T) {
+	e := &Entry{Stored: testNow, Lifetime: time.Minute}
+	for _, tt := range []struct {
+		name           string
+		at             time.Duration // After Stored
+		window         time.Duration
+		mustRevalidate bool
+		fresh, stale   bool
+	}{
+		{"fresh", 30 * time.Second, 0, false, true, false},
+		{"fresh with window", 30 * time.Second, time.Minute, false, true, true},
+		{"expired", time.Minute, 0, false, false, false},
+		{"in the stale window", 90 * time.Second, time.Minute, false, false, true},
+		{"past the stale window", 2 * time.Minute, time.Minute, false, false, false},
+		{"must-revalidate", 90 * time.Second, time.Minute, true, false, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e.MustRevalidate = tt.mustRevalidate
+			now := testNow.Add(tt.at)
+			if got := e.Fresh(now); got != tt.fresh {
+				t.Errorf("Fresh = %v, want %v", got, tt.fresh)
+			}
+			if got := e.Stale(now, tt.window); got != tt.stale {
+				t.Errorf("Stale = %v, want %v", got, tt.stale)
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		etag         string
+		lastModified string
+		headers      map[string]string
+		want         bool
+	}{
+		{"matching ETag", `"v1"`, "", map[string]string{"If-None-Match": `"v1"`}, true},
+		{"one of several", `"v1"`, "", map[string]string{"If-None-Match": `"v0", "v1"`}, true},
+		{"weak comparison", `W/"v1"`, "", map[string]string{"If-None-Match": `"v1"`}, true},
+		{"wildcard", `"v1"`, "", map[string]string{"If-None-Match": "*"}, true},
+		{"other ETag", `"v1"`, "", map[string]string{"If-None-Match": `"v2"`}, false},
+		{"no stored ETag", "", "Wed, 01 May 2024 10:00:00 GMT", map[string]string{"If-None-Match": `"v1"`}, false},
+		{"If-None-Match wins", `"v1"`, "Wed, 01 May 2024 10:00:00 GMT", map[string]string{"If-None-Match": `"v2"`, "If-Modified-Since": "Wed, 01 May 2024 11:00:00 GMT"}, false},
+		{"not modified since", "", "Wed, 01 May 2024 10:00:00 GMT", map[string]string{"If-Modified-Since": "Wed, 01 May 2024 10:00:00 GMT"}, true},
+		{"modified since", "", "Wed, 01 May 2024 10:00:00 GMT", map[string]string{"If-Modified-Since": "Wed, 01 May 2024 09:00:00 GMT"}, false},
+		{"no Last-Modified", `"v1"`, "", map[string]string{"If-Modified-Since": "Wed, 01 May 2024 09:00:00 GMT"}, false},
+		{"unconditional", `"v1"`, "Wed, 01 May 2024 10:00:00 GMT", nil, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var h fasthttp.RequestHeader
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			e := &Entry{ETag: tt.etag, LastModified: tt.lastModified}
+			if got := e.NotModified(&h); got != tt.want {
+				t.Errorf("NotModified = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
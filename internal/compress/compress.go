@@ -0,0 +1,168 @@
+package compress
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultTypes are compressed when no allowlist is configured
+var DefaultTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+var (
+	enabled = false
+	types   = DefaultTypes
+	minSize = 1024
+	level   = 6
+)
+
+// Setup enables compression for the given MIME types ("text/*" matches any
+// subtype) of responses at least minBytes long. level runs from 1 (fastest)
+// to 9 (smallest) and is mapped onto each encoder's own scale.
+func Setup(mimeTypes []string, minBytes, compressionLevel int) {
+	enabled = true
+	if len(mimeTypes) > 0 {
+		types = mimeTypes
+	}
+	if minBytes > 0 {
+		minSize = minBytes
+	}
+	if compressionLevel >= 1 && compressionLevel <= 9 {
+		level = compressionLevel
+	}
+}
+
+// Encodings in order of preference when the client accepts several equally
+var encodings = []string{"br", "zstd", "gzip"}
+
+// Response compresses the response body in place when the client accepts
+// one of the supported encodings. Streamed and already encoded bodies are
+// left alone.
+func Response(ctx *fasthttp.RequestCtx) {
+	if !enabled || !eligible(ctx) {
+		return
+	}
+
+	// The representation now depends on Accept-Encoding, whether or not this client gets it compressed
+	addVary(&ctx.Response.Header, fasthttp.HeaderAcceptEncoding)
+
+	encoding := negotiate(ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding))
+	if encoding == "" {
+		return
+	}
+
+	body := ctx.Response.Body()
+	var compressed []byte
+	switch encoding {
+	case "br":
+		compressed = fasthttp.AppendBrotliBytesLevel(nil, body, level)
+	case "zstd":
+		compressed = fasthttp.AppendZstdBytesLevel(nil, body, zstdLevel())
+	default:
+		compressed = fasthttp.AppendGzipBytesLevel(nil, body, level)
+	}
+	if len(compressed) >= len(body) {
+		return
+	}
+
+	ctx.Response.SetBodyRaw(compressed)
+	ctx.Response.Header.Set(fasthttp.HeaderContentEncoding, encoding)
+	ctx.Response.Header.Del(fasthttp.HeaderAcceptRanges)
+	// The compressed bytes differ, so a strong validator becomes weak
+	if etag := ctx.Response.Header.Peek(fasthttp.HeaderETag); len(etag) > 0 && !bytes.HasPrefix(etag, []byte("W/")) {
+		ctx.Response.Header.Set(fasthttp.HeaderETag, "W/"+string(etag))
+	}
+}
+
+// eligible reports whether the response may be compressed at all
+func eligible(ctx *fasthttp.RequestCtx) bool {
+	resp := &ctx.Response
+	if ctx.IsHead() || resp.StatusCode() != fasthttp.StatusOK || resp.IsBodyStream() {
+		return false
+	}
+	if len(resp.Header.Peek(fasthttp.HeaderContentEncoding)) > 0 {
+		return false
+	}
+	if bytes.Contains(resp.Header.Peek(fasthttp.HeaderCacheControl), []byte("no-transform")) {
+		return false
+	}
+	if len(resp.Body()) < minSize {
+		return false
+	}
+	return matchType(string(resp.Header.ContentType()))
+}
+
+func matchType(contentType string) bool {
+	mime, _, _ := strings.Cut(contentType, ";")
+	mime = strings.ToLower(strings.TrimSpace(mime))
+	for _, t := range types {
+		if t == mime || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mime, t[:len(t)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate picks the supported encoding with the highest q-value in Accept-Encoding
+func negotiate(acceptEncoding []byte) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(string(acceptEncoding), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[strings.ToLower(strings.TrimSpace(name))] = weight
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range encodings {
+		weight, ok := q[encoding]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ = encoding, weight
+		}
+	}
+	return best
+}
+
+// zstdLevel maps 1-9 onto fasthttp's four zstd levels
+func zstdLevel() int {
+	switch {
+	case level <= 2:
+		return fasthttp.CompressZstdBestSpeed
+	case level <= 6:
+		return fasthttp.CompressZstdDefault
+	case level <= 8:
+		return fasthttp.CompressZstdSpeedBetter
+	}
+	return fasthttp.CompressZstdBestCompression
+}
+
+// addVary appends name to the Vary header unless it is already listed
+func addVary(h *fasthttp.ResponseHeader, name string) {
+	vary := string(h.Peek(fasthttp.HeaderVary))
+	for _, v := range strings.Split(vary, ",") {
+		if v = strings.TrimSpace(v); strings.EqualFold(v, name) || v == "*" {
+			return
+		}
+	}
+	if vary == "" {
+		h.Set(fasthttp.HeaderVary, name)
+		return
+	}
+	h.Set(fasthttp.HeaderVary, vary+", "+name)
+}
@@ -9,22 +9,258 @@ import (
 
 type Config struct {
 	Server struct {
-		Port        int `yaml:"port"`
-		MetricsPort int `yaml:"metrics_port"`
+		Port           int                `yaml:"port"`
+		MetricsPort    int                `yaml:"metrics_port"`
+		AdminToken     string             `yaml:"admin_token"`     // Bearer token for admin changes; without one only localhost may make them
+		TrustedProxies []string           `yaml:"trusted_proxies"` // CIDRs allowed to set X-Forwarded-For / X-Real-IP
+		ProxyProtocol  bool               `yaml:"proxy_protocol"`  // Expect a PROXY v1/v2 header on every connection
+		H2C            bool               `yaml:"h2c"`             // Cleartext HTTP/2 (prior knowledge) on the plain listener
+		Limits         ServerLimitsConfig `yaml:"limits"`
 	} `yaml:"server"`
 	LoadBalancer struct {
-		Algorithm string `yaml:"algorithm"`
-		Timeout   string `yaml:"timeout"`
+		Algorithm          string `yaml:"algorithm"`
+		Timeout            string `yaml:"timeout"`
+		UpgradeIdleTimeout string `yaml:"upgrade_idle_timeout"` // WebSocket / Upgrade tunnels
+		Streaming          bool   `yaml:"streaming"`            // Stream request/response bodies instead of buffering
+		StreamIdleTimeout  string `yaml:"stream_idle_timeout"`
 	} `yaml:"load_balancer"`
+	ForwardProxy struct {
+		Enabled           bool               `yaml:"enabled"`         // Accept absolute-form URIs and CONNECT (HTTP_PROXY / HTTPS_PROXY)
+		ConnectTimeout    string             `yaml:"connect_timeout"` // Dialing the destination
+		Timeout           string             `yaml:"timeout"`         // Waiting for the upstream response
+		TunnelIdleTimeout string             `yaml:"tunnel_idle_timeout"`
+		Egress            EgressPolicyConfig `yaml:"egress"` // Also applies to /forward?target=
+		Auth              ProxyAuthConfig    `yaml:"auth"`
+	} `yaml:"forward_proxy"`
 	Firewall struct {
-		Enabled    bool     `yaml:"enabled"`
-		BlockedIPs []string `yaml:"blocked_ips"`
+		Enabled       bool            `yaml:"enabled"`
+		BlockedIPs    []string        `yaml:"blocked_ips"`
+		RateLimit     RateLimitConfig `yaml:"rate_limit"`     // Per client IP; default 100 per minute
+		BlockDuration string          `yaml:"block_duration"` // For IPs over the rate limit, default 5m; "0s" only rejects
 	} `yaml:"firewall"`
+	TLS         TLSConfig `yaml:"tls"`
 	HealthCheck struct {
-		Enabled  bool     `yaml:"enabled"`
-		Interval string   `yaml:"interval"`
-		Backends []string `yaml:"backends"`
+		Enabled  bool             `yaml:"enabled"`
+		Interval string           `yaml:"interval"`
+		Backends []string         `yaml:"backends"`
+		TLS      BackendTLSConfig `yaml:"tls"` // For https:// backends of the default pool
 	} `yaml:"health_check"`
+	Cache       CacheConfig `yaml:"cache"`
+	Compression struct {
+		Enabled bool     `yaml:"enabled"`
+		Types   []string `yaml:"types"`    // MIME allowlist, e.g. "text/*", "application/json"
+		MinSize int      `yaml:"min_size"` // Bytes
+		Level   int      `yaml:"level"`    // 1 (fastest) to 9 (smallest)
+	} `yaml:"compression"`
+	ErrorPages map[int]ErrorPageConfig `yaml:"error_pages"` // By status, for every request
+	Pools      []PoolConfig            `yaml:"pools"`
+	Routes     []RouteConfig           `yaml:"routes"`
+	Listeners  []ListenerConfig        `yaml:"listeners"`
+}
+
+// ErrorPageConfig replaces the proxy's own plain-text error for a status.
+// JSON is served to clients that prefer it to HTML.
+type ErrorPageConfig struct {
+	HTML string `yaml:"html"` // File path
+	JSON string `yaml:"json"` // File path
+}
+
+// EgressPolicyConfig limits the destinations the forward proxy connects to.
+// Denials win; when allowed hosts or CIDRs are set, destinations must match one.
+type EgressPolicyConfig struct {
+	AllowedHosts []string `yaml:"allowed_hosts"` // e.g. "github.com", "*.golang.org"
+	DeniedHosts  []string `yaml:"denied_hosts"`
+	AllowedCIDRs []string `yaml:"allowed_cidrs"` // Also exempt from the private range block
+	DeniedCIDRs  []string `yaml:"denied_cidrs"`
+	AllowedPorts []int    `yaml:"allowed_ports"`
+	DeniedPorts  []int    `yaml:"denied_ports"`
+	AllowPrivate bool     `yaml:"allow_private"` // Private, loopback, link-local and reserved ranges are blocked by default
+}
+
+// ProxyAuthConfig requires Proxy-Authorization on forward proxy requests
+type ProxyAuthConfig struct {
+	Enabled      bool               `yaml:"enabled"`
+	Realm        string             `yaml:"realm"`
+	HtpasswdFile string             `yaml:"htpasswd_file"` // Basic credentials, bcrypt hashes only (htpasswd -B)
+	Tokens       []ProxyTokenConfig `yaml:"tokens"`        // Bearer tokens
+	Users        []ProxyUserConfig  `yaml:"users"`
+}
+
+// ProxyTokenConfig maps a bearer token to a user
+type ProxyTokenConfig struct {
+	User  string `yaml:"user"`
+	Token string `yaml:"token"`
+}
+
+// ProxyUserConfig holds optional per-user restrictions
+type ProxyUserConfig struct {
+	Name         string   `yaml:"name"`
+	AllowedHosts []string `yaml:"allowed_hosts"` // Destination host patterns; any host when empty
+	RateLimit    float64  `yaml:"rate_limit"`    // Requests per second; 0 is unlimited
+	Burst        int      `yaml:"burst"`
+}
+
+// CacheConfig configures the HTTP response cache used by routes with cache: true
+type CacheConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	MaxMemoryMB     int    `yaml:"max_memory_mb"`
+	MaxEntryKB      int    `yaml:"max_entry_kb"` // Larger responses are not stored
+	DiskDir         string `yaml:"disk_dir"`     // Optional second tier for entries evicted from memory
+	MaxDiskMB       int    `yaml:"max_disk_mb"`
+	DefaultTTL      string `yaml:"default_ttl"`      // For responses without Cache-Control or Expires
+	CoalesceTimeout string `yaml:"coalesce_timeout"` // Concurrent misses wait this long for one upstream fetch; 0s disables
+}
+
+// ListenerConfig is an extra layer-4 listener balancing connections across a pool
+type ListenerConfig struct {
+	Name              string `yaml:"name"`
+	Mode              string `yaml:"mode"` // tcp or udp
+	Port              int    `yaml:"port"`
+	Pool              string `yaml:"pool"`
+	ConnectTimeout    string `yaml:"connect_timeout"`
+	IdleTimeout       string `yaml:"idle_timeout"`
+	ProxyProtocol     bool   `yaml:"proxy_protocol"`      // Accept PROXY headers, like server.proxy_protocol
+	SendProxyProtocol string `yaml:"send_proxy_protocol"` // v1 or v2 header sent to backends; defaults to the pool's
+	SessionTimeout    string `yaml:"session_timeout"`     // udp: idle time before a client session is dropped
+	MaxSessions       int    `yaml:"max_sessions"`        // udp: cap on concurrent client sessions
+	Affinity          bool   `yaml:"affinity"`            // udp: hash the client IP to pick the backend
+}
+
+// PoolConfig is a named group of backends
+type PoolConfig struct {
+	Name              string           `yaml:"name"`
+	Algorithm         string           `yaml:"algorithm"` // Defaults to load_balancer.algorithm
+	Timeout           string           `yaml:"timeout"`   // Backend requests; defaults to load_balancer.timeout
+	Backends          []string         `yaml:"backends"`
+	Protocol          string           `yaml:"protocol"`            // http1 (default), h2 or h2c
+	HealthCheck       string           `yaml:"health_check"`        // http (GET /health, default), grpc, tcp or none
+	GRPCHealthService string           `yaml:"grpc_health_service"` // Service name for grpc.health.v1
+	SendProxyProtocol string           `yaml:"send_proxy_protocol"` // v1 or v2 header on each backend connection
+	TLS               BackendTLSConfig `yaml:"tls"`
+}
+
+// BackendTLSConfig configures TLS and mutual TLS to https:// backends
+type BackendTLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"` // Client certificate for mTLS
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`          // SNI / verification name override
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // Development only
+}
+
+// ServerLimitsConfig bounds what a client may send to the proxy listeners.
+// Oversized headers get 431, oversized bodies 413 and slow clients 408.
+type ServerLimitsConfig struct {
+	MaxHeaderSize   int    `yaml:"max_header_size"`   // Bytes, default 8192
+	MaxBodySize     int    `yaml:"max_body_size"`     // Bytes, default 4 MiB; routes may set their own
+	ReadTimeout     string `yaml:"read_timeout"`      // Whole request, headers and body
+	WriteTimeout    string `yaml:"write_timeout"`     // Whole response; keep it long with streaming downloads
+	IdleTimeout     string `yaml:"idle_timeout"`      // Keep-alive wait for the next request
+	MaxConnsPerIP   int    `yaml:"max_conns_per_ip"`  // 0 is unlimited; over the limit gets 429
+	MinTransferRate int    `yaml:"min_transfer_rate"` // Bytes per second a request must arrive at after 5s; 0 disables
+}
+
+// RouteConfig sends requests matching a path prefix to a pool
+type RouteConfig struct {
+	PathPrefix  string          `yaml:"path_prefix"`
+	Type        string          `yaml:"type"`          // http (default) or grpc
+	Pool        string          `yaml:"pool"`          // May be empty if the route has a split or redirect rules
+	Split       SplitConfig     `yaml:"split"`         // Weighted pools instead of pool
+	Cache       bool            `yaml:"cache"`         // Serve GET/HEAD through the response cache
+	Mirror      MirrorConfig    `yaml:"mirror"`        // Copy requests to a shadow pool
+	MaxBodySize int             `yaml:"max_body_size"` // Bytes, instead of server.limits.max_body_size
+	Hedge       HedgeConfig     `yaml:"hedge"`         // GET, HEAD and OPTIONS on HTTP/1.1 pools
+	RateLimit   RateLimitConfig `yaml:"rate_limit"`
+
+	Rewrite         []RewriteRuleConfig     `yaml:"rewrite"`          // First match applies (http routes)
+	RequestHeaders  HeaderRulesConfig       `yaml:"request_headers"`  // Before proxying (http routes)
+	ResponseHeaders HeaderRulesConfig       `yaml:"response_headers"` // Before returning
+	ErrorPages      map[int]ErrorPageConfig `yaml:"error_pages"`      // By status, before the global ones
+}
+
+// SplitConfig divides a route's traffic between pools by weight. A header or
+// cookie whose value names a pool forces that pool; sticky assigns clients by
+// a hash of their IP instead of at random. Weights can be changed with
+// PUT /admin/splits.
+type SplitConfig struct {
+	Pools  []SplitPoolConfig `yaml:"pools"`
+	Header string            `yaml:"header"`
+	Cookie string            `yaml:"cookie"`
+	Sticky bool              `yaml:"sticky"`
+}
+
+// MirrorConfig copies a share of a route's requests to a shadow pool and
+// discards the responses
+type MirrorConfig struct {
+	Pool          string  `yaml:"pool"`
+	Percent       float64 `yaml:"percent"`        // 0 to 100
+	MaxConcurrent int     `yaml:"max_concurrent"` // Copies beyond this are dropped; default 100
+	Timeout       string  `yaml:"timeout"`        // Default 5s
+}
+
+// HedgeConfig sends a second copy of a slow request to another backend and
+// uses the first answer
+type HedgeConfig struct {
+	Enabled bool    `yaml:"enabled"`
+	Delay   string  `yaml:"delay"`  // A duration, or a percentile of the route's latency such as "p95"; default 100ms
+	Budget  float64 `yaml:"budget"` // Percent of requests that may be hedged; default 10
+}
+
+// RateLimitConfig allows requests per key at a sustained rate, with a burst
+// allowance on top. Requests over it get 429 with Retry-After, and every
+// response carries RateLimit-* headers.
+type RateLimitConfig struct {
+	Requests int    `yaml:"requests"` // Per period; 0 disables the limit
+	Period   string `yaml:"period"`   // Default 1m
+	Burst    int    `yaml:"burst"`    // Requests allowed at once; default requests
+	Key      string `yaml:"key"`      // ip (default), path, header:<name> or jwt:<claim>; routes only
+	MaxKeys  int    `yaml:"max_keys"` // Keys tracked at once, least recently seen evicted first; default 100000
+}
+
+// SplitPoolConfig is one pool of a split and its weight
+type SplitPoolConfig struct {
+	Pool   string `yaml:"pool"`
+	Weight int    `yaml:"weight"`
+}
+
+// RewriteRuleConfig rewrites the path, query and host sent upstream, or
+// redirects. Path may use regexp captures as $1 or ${name}.
+type RewriteRuleConfig struct {
+	Match       string            `yaml:"match"` // Regexp on the path; empty matches every request
+	Path        string            `yaml:"path"`
+	Host        string            `yaml:"host"`
+	Scheme      string            `yaml:"scheme"` // http or https, redirects only
+	AddQuery    map[string]string `yaml:"add_query"`
+	RemoveQuery []string          `yaml:"remove_query"`
+	Redirect    int               `yaml:"redirect"` // 301, 302, 307 or 308; 0 proxies the rewritten request
+}
+
+// HeaderRulesConfig removes, then sets, then adds headers. Values may use
+// ${client_ip}, ${request_id}, ${backend}, ${route}, ${pool}, ${host},
+// ${method}, ${path}, ${time} and ${time_unix}.
+type HeaderRulesConfig struct {
+	Add     map[string]string `yaml:"add"`
+	Set     map[string]string `yaml:"set"`
+	Remove  []string          `yaml:"remove"`
+	Presets []string          `yaml:"presets"` // hsts, security
+}
+
+// TLSConfig configures HTTPS termination on the proxy listener
+type TLSConfig struct {
+	Enabled          bool                `yaml:"enabled"`
+	Port             int                 `yaml:"port"`
+	MinVersion       string              `yaml:"min_version"`   // "1.0" to "1.3", default "1.2"
+	CipherSuites     []string            `yaml:"cipher_suites"` // Go cipher suite names, TLS 1.2 and below
+	ReloadInterval   string              `yaml:"reload_interval"`
+	HTTPRedirectPort int                 `yaml:"http_redirect_port"` // 0 disables the HTTP -> HTTPS redirect listener
+	HTTP2            bool                `yaml:"http2"`              // Offer h2 via ALPN
+	Certificates     []CertificateConfig `yaml:"certificates"`
+}
+
+// CertificateConfig is a certificate/key pair, selected by SNI
+type CertificateConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
 }
 
 func LoadConfig(filePath string) (*Config, error) {
@@ -0,0 +1,262 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/realip"
+)
+
+// Rules describes which destinations the forward proxy may connect to.
+// Denials always win. When AllowedHosts or AllowedCIDRs are set, a
+// destination must match one of them.
+type Rules struct {
+	AllowedHosts []string // Exact names, "*.example.com" for subdomains or "*"
+	DeniedHosts  []string
+	AllowedCIDRs []string // Also exempts these ranges from the private range block
+	DeniedCIDRs  []string
+	AllowedPorts []int // Any port when empty
+	DeniedPorts  []int
+	AllowPrivate bool // Allow private, loopback, link-local and other reserved addresses
+}
+
+// DeniedError is returned when the policy refuses a destination
+type DeniedError struct {
+	Addr   string
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("egress to %s denied: %s", e.Addr, e.Reason)
+}
+
+// IsDenied reports whether err (or an error it wraps) is a policy denial
+func IsDenied(err error) (*DeniedError, bool) {
+	var denied *DeniedError
+	ok := errors.As(err, &denied)
+	return denied, ok
+}
+
+// Policy checks destinations against Rules
+type Policy struct {
+	allowedHosts []string
+	deniedHosts  []string
+	allowedCIDRs []*net.IPNet
+	deniedCIDRs  []*net.IPNet
+	allowedPorts map[int]bool
+	deniedPorts  map[int]bool
+	allowPrivate bool
+}
+
+// NewPolicy validates rules and builds a Policy
+func NewPolicy(rules Rules) (*Policy, error) {
+	allowedCIDRs, err := realip.ParseCIDRs(rules.AllowedCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed CIDR: %w", err)
+	}
+	deniedCIDRs, err := realip.ParseCIDRs(rules.DeniedCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid denied CIDR: %w", err)
+	}
+
+	p := &Policy{
+		allowedHosts: normalizeHosts(rules.AllowedHosts),
+		deniedHosts:  normalizeHosts(rules.DeniedHosts),
+		allowedCIDRs: allowedCIDRs,
+		deniedCIDRs:  deniedCIDRs,
+		allowedPorts: map[int]bool{},
+		deniedPorts:  map[int]bool{},
+		allowPrivate: rules.AllowPrivate,
+	}
+	for _, port := range rules.AllowedPorts {
+		p.allowedPorts[port] = true
+	}
+	for _, port := range rules.DeniedPorts {
+		p.deniedPorts[port] = true
+	}
+	return p, nil
+}
+
+func normalizeHosts(hosts []string) []string {
+	var normalized []string
+	for _, h := range hosts {
+		if h = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(h), ".")); h != "" {
+			normalized = append(normalized, h)
+		}
+	}
+	return normalized
+}
+
+// MatchHost reports whether host matches one of the patterns: exact names,
+// "*.example.com" for any subdomain, or "*"
+func MatchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		switch {
+		case pattern == "*", pattern == host:
+			return true
+		case strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedRanges reach internal hosts without being private in the net
+// package's sense: carrier-grade NAT, "this network", benchmarking, and the
+// NAT64 and 6to4 prefixes, which embed an IPv4 address
+var reservedRanges = mustParseCIDRs("100.64.0.0/10", "0.0.0.0/8", "198.18.0.0/15", "64:ff9b::/96", "2002::/16")
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks, err := realip.ParseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+// isPrivate reports whether ip is private, loopback, link-local (e.g. cloud
+// metadata at 169.254.169.254), unspecified, multicast or in reservedRanges
+func isPrivate(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsInterfaceLocalMulticast() || containsIP(reservedRanges, ip)
+}
+
+// checkName applies the port and host name rules, before any DNS lookup
+func (p *Policy) checkName(host string, port int) string {
+	if p.deniedPorts[port] {
+		return fmt.Sprintf("port %d is denied", port)
+	}
+	if len(p.allowedPorts) > 0 && !p.allowedPorts[port] {
+		return fmt.Sprintf("port %d is not allowed", port)
+	}
+	if MatchHost(p.deniedHosts, host) {
+		return fmt.Sprintf("host %s is denied", host)
+	}
+	return ""
+}
+
+// checkIP applies the CIDR rules to one resolved address of host
+func (p *Policy) checkIP(host string, ip net.IP) string {
+	if containsIP(p.deniedCIDRs, ip) {
+		return fmt.Sprintf("address %s is in a denied range", ip)
+	}
+	inAllowedCIDR := containsIP(p.allowedCIDRs, ip)
+	if (len(p.allowedHosts) > 0 || len(p.allowedCIDRs) > 0) &&
+		!MatchHost(p.allowedHosts, host) && !inAllowedCIDR {
+		if host == ip.String() {
+			return fmt.Sprintf("%s is not in the allowed hosts or ranges", host)
+		}
+		return fmt.Sprintf("%s (%s) is not in the allowed hosts or ranges", host, ip)
+	}
+	if isPrivate(ip) && !p.allowPrivate && !inAllowedCIDR {
+		return fmt.Sprintf("address %s is private, loopback, link-local or reserved", ip)
+	}
+	return ""
+}
+
+// Dial resolves addr (host:port), checks the name and every candidate
+// address, and connects to the first permitted address. Connecting to the
+// address that was checked, rather than resolving again, defeats DNS rebinding.
+func (p *Policy) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, &DeniedError{Addr: addr, Reason: fmt.Sprintf("invalid port %q", portStr)}
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if reason := p.checkName(host, port); reason != "" {
+		return nil, &DeniedError{Addr: addr, Reason: reason}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+
+	var denied *DeniedError
+	var dialErr error
+	var dialer net.Dialer
+	for _, ip := range ips {
+		if reason := p.checkIP(host, ip); reason != "" {
+			if denied == nil {
+				denied = &DeniedError{Addr: addr, Reason: reason}
+			}
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), portStr))
+		if err == nil {
+			return conn, nil
+		}
+		dialErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if dialErr != nil {
+		return nil, dialErr
+	}
+	if denied != nil {
+		return nil, denied
+	}
+	return nil, fmt.Errorf("no addresses for %s", host)
+}
+
+var (
+	mu     sync.RWMutex
+	policy *Policy
+)
+
+// SetRules replaces the package-level policy used by Dial
+func SetRules(rules Rules) error {
+	p, err := NewPolicy(rules)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	policy = p
+	mu.Unlock()
+	return nil
+}
+
+// Dial connects to addr under the package-level policy. Until SetRules is
+// called, private, loopback and link-local destinations are refused.
+func Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	mu.RLock()
+	p := policy
+	mu.RUnlock()
+	if p == nil {
+		p = &Policy{}
+	}
+	return p.Dial(addr, timeout)
+}
@@ -0,0 +1,158 @@
+package egress
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustPolicy(t *testing.T, rules Rules) *Policy {
+	t.Helper()
+	p, err := NewPolicy(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCheckName(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		rules Rules
+		host  string
+		port  int
+		want  string // Substring of the reason; empty when allowed
+	}{
+		{"no rules", Rules{}, "example.com", 443, ""},
+		{"denied port", Rules{DeniedPorts: []int{25}}, "example.com", 25, "port 25 is denied"},
+		{"port not allowed", Rules{AllowedPorts: []int{443}}, "example.com", 80, "port 80 is not allowed"},
+		{"allowed port", Rules{AllowedPorts: []int{443}}, "example.com", 443, ""},
+		{"denied port wins", Rules{AllowedPorts: []int{25}, DeniedPorts: []int{25}}, "example.com", 25, "port 25 is denied"},
+		{"denied host", Rules{DeniedHosts: []string{"evil.com"}}, "evil.com", 443, "host evil.com is denied"},
+		{"denied subdomain", Rules{DeniedHosts: []string{"*.evil.com"}}, "a.evil.com", 443, "host a.evil.com is denied"},
+		{"wildcard leaves the apex", Rules{DeniedHosts: []string{"*.evil.com"}}, "evil.com", 443, ""},
+		{"denial wins over allowlist", Rules{AllowedHosts: []string{"*"}, DeniedHosts: []string{"evil.com"}}, "evil.com", 443, "denied"},
+		{"normalized", Rules{DeniedHosts: []string{" Evil.COM. "}}, "evil.com", 443, "denied"},
+		{"denied IP literal", Rules{DeniedHosts: []string{"192.0.2.1"}}, "192.0.2.1", 443, "host 192.0.2.1 is denied"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mustPolicy(t, tt.rules).checkName(tt.host, tt.port)
+			if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+				t.Errorf("checkName(%s, %d) = %q, want %q", tt.host, tt.port, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckIP(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		rules Rules
+		host  string
+		ip    string
+		want  string
+	}{
+		{"public", Rules{}, "example.com", "93.184.216.34", ""},
+		{"loopback", Rules{}, "localhost", "127.0.0.1", "private"},
+		{"private", Rules{}, "10.0.0.1", "10.0.0.1", "private"},
+		{"metadata", Rules{}, "169.254.169.254", "169.254.169.254", "private"},
+		{"IPv6 loopback", Rules{}, "::1", "::1", "private"},
+		{"IPv4-mapped loopback", Rules{}, "::ffff:127.0.0.1", "::ffff:127.0.0.1", "private"},
+		{"carrier-grade NAT", Rules{}, "100.64.0.1", "100.64.0.1", "reserved"},
+		{"this network", Rules{}, "0.1.2.3", "0.1.2.3", "reserved"},
+		{"benchmarking", Rules{}, "198.19.0.1", "198.19.0.1", "reserved"},
+		{"NAT64", Rules{}, "64:ff9b::a00:1", "64:ff9b::a00:1", "reserved"},
+		{"6to4", Rules{}, "2002:a00:1::1", "2002:a00:1::1", "reserved"},
+		{"allow private", Rules{AllowPrivate: true}, "100.64.0.1", "100.64.0.1", ""},
+		{"allowed CIDR exempts the private block", Rules{AllowedCIDRs: []string{"10.0.0.0/8"}}, "internal", "10.0.0.1", ""},
+		{"allowed host does not exempt the private block", Rules{AllowedHosts: []string{"internal"}}, "internal", "10.0.0.1", "private"},
+		{"denied CIDR wins over allowed CIDR", Rules{AllowedCIDRs: []string{"10.0.0.0/8"}, DeniedCIDRs: []string{"10.0.0.0/24"}}, "internal", "10.0.0.1", "denied range"},
+		{"denied CIDR wins over allow private", Rules{AllowPrivate: true, DeniedCIDRs: []string{"10.0.0.0/8"}}, "internal", "10.0.0.1", "denied range"},
+		{"name outside the allowlist", Rules{AllowedHosts: []string{"example.com"}}, "other.com", "93.184.216.34", "other.com (93.184.216.34) is not in the allowed"},
+		{"IP literal outside the allowlist", Rules{AllowedHosts: []string{"example.com"}}, "93.184.216.34", "93.184.216.34", "93.184.216.34 is not in the allowed"},
+		{"name in the allowlist", Rules{AllowedHosts: []string{"*.example.com"}}, "www.example.com", "93.184.216.34", ""},
+		{"address in an allowed CIDR", Rules{AllowedHosts: []string{"example.com"}, AllowedCIDRs: []string{"93.184.216.0/24"}}, "other.com", "93.184.216.34", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mustPolicy(t, tt.rules).checkIP(tt.host, net.ParseIP(tt.ip))
+			if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+				t.Errorf("checkIP(%s, %s) = %q, want %q", tt.host, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+
+	for _, tt := range []struct {
+		name   string
+		rules  Rules
+		addr   string
+		denied string // Substring of the denial; empty when the dial succeeds
+	}{
+		{"loopback literal", Rules{}, "127.0.0.1:" + port, "private"},
+		{"loopback name", Rules{}, "localhost:" + port, "private"},
+		{"allow private", Rules{AllowPrivate: true}, "127.0.0.1:" + port, ""},
+		{"allow private by name", Rules{AllowPrivate: true}, "localhost:" + port, ""},
+		{"allowed CIDR", Rules{AllowedCIDRs: []string{"127.0.0.0/8"}}, "127.0.0.1:" + port, ""},
+		{"allowed host needs its range", Rules{AllowedHosts: []string{"localhost"}}, "localhost:" + port, "private"},
+		{"denied before resolving", Rules{AllowPrivate: true, DeniedHosts: []string{"*.invalid"}}, "nowhere.invalid:" + port, "host nowhere.invalid is denied"},
+		{"denied port", Rules{AllowPrivate: true, DeniedPorts: []int{ln.Addr().(*net.TCPAddr).Port}}, "127.0.0.1:" + port, "is denied"},
+		{"invalid port", Rules{AllowPrivate: true}, "127.0.0.1:http", "invalid port"},
+		{"denied range", Rules{AllowPrivate: true, DeniedCIDRs: []string{"127.0.0.1/32"}}, "127.0.0.1:" + port, "denied range"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := mustPolicy(t, tt.rules).Dial(tt.addr, time.Second)
+			if tt.denied == "" {
+				if err != nil {
+					t.Fatalf("Dial(%s): %v", tt.addr, err)
+				}
+				conn.Close()
+				return
+			}
+			if err == nil {
+				conn.Close()
+				t.Fatalf("Dial(%s) connected, want %q", tt.addr, tt.denied)
+			}
+			denied, ok := IsDenied(err)
+			if !ok || !strings.Contains(denied.Reason, tt.denied) {
+				t.Errorf("Dial(%s): %v, want a denial with %q", tt.addr, err, tt.denied)
+			}
+		})
+	}
+}
+
+func TestDialWithoutRulesRefusesLoopback(t *testing.T) {
+	mu.Lock()
+	saved := policy
+	policy = nil
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		policy = saved
+		mu.Unlock()
+	}()
+
+	if _, err := Dial("127.0.0.1:80", time.Second); err == nil {
+		t.Fatal("loopback was dialled before SetRules")
+	} else if _, ok := IsDenied(err); !ok {
+		t.Fatalf("got %v, want a denial", err)
+	}
+}
@@ -0,0 +1,125 @@
+package errorpages
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// UserValue marks responses written by the proxy rather than a backend
+const UserValue = "errorpages.generated"
+
+// Files names the HTML and JSON variants of a page; either may be empty
+type Files struct {
+	HTML string
+	JSON string
+}
+
+type page struct {
+	html []byte
+	json []byte
+}
+
+// Pages are error pages by status code
+type Pages struct {
+	pages map[int]page
+}
+
+// defaults apply to every request, after a route's own pages
+var defaults *Pages
+
+// SetDefaults sets the pages used when a route has none for a status
+func SetDefaults(p *Pages) {
+	defaults = p
+}
+
+// Load reads the page files for each status
+func Load(files map[int]Files) (*Pages, error) {
+	p := &Pages{pages: make(map[int]page, len(files))}
+	for status, f := range files {
+		if status < 400 || status > 599 {
+			return nil, fmt.Errorf("error page for status %d: not an error status", status)
+		}
+		var pg page
+		var err error
+		if f.HTML != "" {
+			if pg.html, err = os.ReadFile(f.HTML); err != nil {
+				return nil, fmt.Errorf("error page for status %d: %w", status, err)
+			}
+		}
+		if f.JSON != "" {
+			if pg.json, err = os.ReadFile(f.JSON); err != nil {
+				return nil, fmt.Errorf("error page for status %d: %w", status, err)
+			}
+		}
+		p.pages[status] = pg
+	}
+	return p, nil
+}
+
+func (p *Pages) lookup(status int) (page, bool) {
+	if p == nil {
+		return page{}, false
+	}
+	pg, ok := p.pages[status]
+	return pg, ok
+}
+
+// Error answers with status and a plain-text message, which Render may
+// replace with a configured page
+func Error(ctx *fasthttp.RequestCtx, msg string, status int) {
+	ctx.Error(msg, status)
+	ctx.SetUserValue(UserValue, true)
+}
+
+// Render replaces the body of an error written with Error by the route's
+// page for its status, or the default page. JSON is used when the client
+// prefers it to HTML. Backend responses are left alone.
+func Render(ctx *fasthttp.RequestCtx, route *Pages) {
+	if generated, _ := ctx.UserValue(UserValue).(bool); !generated {
+		return
+	}
+	status := ctx.Response.StatusCode()
+	pg, ok := route.lookup(status)
+	if !ok {
+		if pg, ok = defaults.lookup(status); !ok {
+			return
+		}
+	}
+
+	body, contentType := pg.html, "text/html; charset=utf-8"
+	if pg.json != nil && (pg.html == nil || prefersJSON(ctx.Request.Header.Peek(fasthttp.HeaderAccept))) {
+		body, contentType = pg.json, "application/json"
+	}
+	if body == nil {
+		return
+	}
+	ctx.SetContentType(contentType)
+	ctx.SetBody(body)
+}
+
+// prefersJSON reports whether an Accept header ranks application/json above text/html
+func prefersJSON(accept []byte) bool {
+	jsonQ, htmlQ := -1.0, -1.0
+	for _, part := range strings.Split(string(accept), ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "application/json":
+			jsonQ = q
+		case "text/html":
+			htmlQ = q
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
@@ -1,99 +1,174 @@
 package firewall
 
 import (
+	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/siddhu949/leanbalancer/internal/errorpages"
+	"github.com/siddhu949/leanbalancer/internal/ratelimit"
+	"github.com/siddhu949/leanbalancer/internal/realip"
+	"github.com/siddhu949/leanbalancer/internal/shardmap"
 	"github.com/valyala/fasthttp"
 )
 
-// Rate limit settings
-const (
-	requestLimit  = 100             // Max requests per IP
-	windowSize    = time.Minute     // Time window for rate limiting
-	blockDuration = 5 * time.Minute // Block duration after exceeding limit
+// rateLimit is the per-IP limit and how long IPs that exceed it are blocked
+type rateLimit struct {
+	limiter       *ratelimit.Limiter
+	blockDuration time.Duration
+}
+
+// defaultBlockDuration applies to BlockIP until SetRateLimit is called
+const defaultBlockDuration = 5 * time.Minute
+
+// Nil until SetRateLimit is called, meaning no limit
+var currentLimit atomic.Pointer[rateLimit]
+
+// Temporarily blocked IPs and their unblock time, bounded like the limiter's
+// keys. Created, with its sweeper, when the first IP is blocked.
+var (
+	blockedIPs  atomic.Pointer[shardmap.Map[time.Time]]
+	blockedOnce sync.Once
 )
 
-// Memory-efficient sync.Map for tracking requests & blocked IPs
+// SetRateLimit replaces the per-IP limit; nil disables it. A zero
+// blockFor only rejects requests over the limit instead of blocking the IP.
+// The limiter's MaxKeys also caps how many IPs are blocked at once; when
+// more are, the least recently blocked are released first.
+func SetRateLimit(l *ratelimit.Limiter, blockFor time.Duration) {
+	old := currentLimit.Swap(&rateLimit{limiter: l, blockDuration: blockFor})
+	if old != nil && old.limiter != nil && old.limiter != l {
+		old.limiter.Close()
+	}
+}
+
+// Permanent block list from config (IPs or CIDRs)
 var (
-	requestCounts sync.Map // Stores request counts per IP
-	blockedIPs    sync.Map // Stores blocked IPs & unblock time
+	staticMu      sync.RWMutex
+	staticBlocked []*net.IPNet
 )
 
+// SetBlockedIPs replaces the permanent block list
+func SetBlockedIPs(entries []string) error {
+	networks, err := realip.ParseCIDRs(entries)
+	if err != nil {
+		return err
+	}
+	staticMu.Lock()
+	staticBlocked = networks
+	staticMu.Unlock()
+	return nil
+}
+
+// IsBlocked reports whether an IP is permanently or temporarily blocked
+func IsBlocked(ip string) bool {
+	if unblockTime, exists := getBlockedIP(ip); exists && time.Now().Before(unblockTime) {
+		return true
+	}
+	return isStaticBlocked(ip)
+}
+
+func isStaticBlocked(ip string) bool {
+	parsed := net.ParseIP(ip)
+	staticMu.RLock()
+	defer staticMu.RUnlock()
+	for _, network := range staticBlocked {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
 // FirewallMiddleware checks rate limits and blocks IPs if needed
 func FirewallMiddleware(ctx *fasthttp.RequestCtx) bool {
-	clientIP := ctx.RemoteIP().String()
+	clientIP := realip.ClientIP(ctx)
+
+	// ✅ Check the permanent block list
+	if isStaticBlocked(clientIP) {
+		errorpages.Error(ctx, "Access denied", fasthttp.StatusForbidden)
+		return false
+	}
 
 	// ✅ Check if the IP is blocked
 	if unblockTime, exists := getBlockedIP(clientIP); exists && time.Now().Before(unblockTime) {
-		ctx.Error("Access Denied: Too many requests", fasthttp.StatusForbidden)
+		errorpages.Error(ctx, "Access denied: too many requests", fasthttp.StatusForbidden)
 		return false
 	}
 
-	// ✅ Update request count
-	count := incrementRequestCount(clientIP)
-	if count > requestLimit {
-		blockIP(clientIP)
-		ctx.Error("Too many requests, access blocked", fasthttp.StatusTooManyRequests)
+	// ✅ Check the rate limit
+	limit := currentLimit.Load()
+	if limit == nil || limit.limiter == nil {
+		return true
+	}
+	if r := limit.limiter.Allow(clientIP); !r.Allowed {
+		if limit.blockDuration > 0 {
+			blockIP(clientIP, limit.blockDuration)
+		}
+		errorpages.Error(ctx, "Too many requests", fasthttp.StatusTooManyRequests)
+		// Set after Error, which resets the response
+		limit.limiter.SetHeaders(&ctx.Response.Header, r)
 		return false
 	}
 
 	return true
 }
 
-// Increments request count for an IP, deletes expired entries
-func incrementRequestCount(ip string) int {
-	// Fetch and increment the request count for this IP
-	count, _ := requestCounts.LoadOrStore(ip, 0) // Initialize at 0 if not found
-	newCount := count.(int) + 1
-	requestCounts.Store(ip, newCount)
-
-	// ✅ Auto-delete after windowSize (Prevents Memory Leak)
-	go func() {
-		time.Sleep(windowSize)
-		requestCounts.Delete(ip) // Free memory after time window
-	}()
-
-	return newCount
-}
-
 // Blocks an IP for a set duration
-func blockIP(ip string) {
-	blockedIPs.Store(ip, time.Now().Add(blockDuration))
-
-	// ✅ Auto-remove block after duration
-	go func() {
-		time.Sleep(blockDuration)
-		blockedIPs.Delete(ip) // Free memory after blocking expires
-	}()
+func blockIP(ip string, duration time.Duration) {
+	blockedOnce.Do(func() {
+		maxKeys := ratelimit.DefaultMaxKeys
+		if limit := currentLimit.Load(); limit != nil && limit.limiter != nil {
+			maxKeys = limit.limiter.MaxKeys
+		}
+		blockedIPs.Store(shardmap.New[time.Time](maxKeys))
+		go sweepBlocked(time.Minute)
+	})
+	blockedIPs.Load().Set(ip, time.Now().Add(duration))
 }
 
 // Gets blocked IP and its unblock time
 func getBlockedIP(ip string) (time.Time, bool) {
-	value, exists := blockedIPs.Load(ip)
-	if exists {
-		return value.(time.Time), true
+	blocked := blockedIPs.Load()
+	if blocked == nil {
+		return time.Time{}, false
+	}
+	return blocked.Get(ip)
+}
+
+// sweepBlocked removes expired blocks; it is the only goroutine that does
+func sweepBlocked(every time.Duration) {
+	for range time.Tick(every) {
+		now := time.Now()
+		blockedIPs.Load().DeleteFunc(func(_ string, unblockTime time.Time) bool {
+			return !now.Before(unblockTime)
+		})
 	}
-	return time.Time{}, false
 }
 
 // GetBlockedIPs returns a list of all blocked IPs
 func GetBlockedIPs() []string {
 	var blocked []string
-	blockedIPs.Range(func(key, value interface{}) bool {
-		blocked = append(blocked, key.(string))
+	m := blockedIPs.Load()
+	if m == nil {
+		return blocked
+	}
+	now := time.Now()
+	m.Range(func(ip string, unblockTime time.Time) bool {
+		if now.Before(unblockTime) {
+			blocked = append(blocked, ip)
+		}
 		return true
 	})
 	return blocked
 }
 
-// BlockIP adds an IP to the blocked list
+// BlockIP adds an IP to the blocked list for the configured block duration
 func BlockIP(ip string) {
-	blockedIPs.Store(ip, time.Now().Add(blockDuration))
-
-	// ✅ Auto-remove block after duration
-	go func() {
-		time.Sleep(blockDuration)
-		blockedIPs.Delete(ip) // Free memory after blocking expires
-	}()
+	duration := defaultBlockDuration
+	if limit := currentLimit.Load(); limit != nil && limit.blockDuration > 0 {
+		duration = limit.blockDuration
+	}
+	blockIP(ip, duration)
 }
@@ -0,0 +1,367 @@
+package h2
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+)
+
+// clientPreface starts every HTTP/2 connection (RFC 9113 section 3.4)
+var clientPreface = []byte(http2.ClientPreface)
+
+const handshakeTimeout = 10 * time.Second
+
+// Per-connection limits of the HTTP/2 server
+const (
+	maxConcurrentStreams = 250
+	maxReadFrameSize     = 64 * 1024
+)
+
+// Limits applies the fasthttp server's request limits to HTTP/2 connections,
+// which are served outside it
+type Limits struct {
+	MaxHeaderSize int
+	ReadTimeout   time.Duration // Per stream: headers and body
+	WriteTimeout  time.Duration // Per stream
+	IdleTimeout   time.Duration // Connections without open streams
+	MaxConnsPerIP int           // HTTP/2 connections, counted apart from HTTP/1.x ones; 0 is unlimited
+
+	// MaxBodySize returns the body limit for a request path; 0 is unlimited
+	MaxBodySize func(path string) int
+	// StreamRequestBody passes bodies to handlers as they arrive instead of
+	// reading them first, like fasthttp.Server.StreamRequestBody
+	StreamRequestBody bool
+}
+
+// User values set on bridged request contexts
+const (
+	userValueHTTP2    = "h2.request"
+	userValueTrailers = "h2.trailers"
+)
+
+// IsHTTP2 reports whether ctx was bridged from an HTTP/2 stream
+func IsHTTP2(ctx *fasthttp.RequestCtx) bool {
+	v, _ := ctx.UserValue(userValueHTTP2).(bool)
+	return v
+}
+
+// SetTrailers registers a function returning trailers to send once the
+// response body has been written, e.g. grpc-status from the backend
+func SetTrailers(ctx *fasthttp.RequestCtx, trailers func() http.Header) {
+	ctx.SetUserValue(userValueTrailers, trailers)
+}
+
+// Listener hands HTTP/2 connections to an http2.Server and passes everything
+// else through Accept, so the fasthttp server keeps serving HTTP/1.1.
+// TLS connections are split by ALPN; plaintext ones by the h2c preface.
+type Listener struct {
+	net.Listener
+	server     *http2.Server
+	baseConfig *http.Server
+	handler    http.Handler
+	h2c        bool
+
+	maxConnsPerIP int
+	perIPMu       sync.Mutex
+	perIP         map[string]int
+
+	conns     chan net.Conn
+	errs      chan error
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewListener wraps l. TLS listeners must advertise "h2" in NextProtos.
+// h2c enables cleartext HTTP/2 with prior knowledge on plain listeners.
+func NewListener(l net.Listener, handler fasthttp.RequestHandler, h2c bool, limits Limits) *Listener {
+	hl := &Listener{
+		Listener: l,
+		server: &http2.Server{
+			IdleTimeout:          limits.IdleTimeout,
+			MaxConcurrentStreams: maxConcurrentStreams,
+			MaxReadFrameSize:     maxReadFrameSize,
+		},
+		// The HTTP/2 server takes per-stream timeouts and the header limit from here
+		baseConfig: &http.Server{
+			ReadTimeout:    limits.ReadTimeout,
+			WriteTimeout:   limits.WriteTimeout,
+			MaxHeaderBytes: limits.MaxHeaderSize,
+		},
+		handler:       Handler(handler, limits),
+		h2c:           h2c,
+		maxConnsPerIP: limits.MaxConnsPerIP,
+		perIP:         make(map[string]int),
+		conns:         make(chan net.Conn),
+		errs:          make(chan error, 1),
+		done:          make(chan struct{}),
+	}
+	go hl.acceptLoop()
+	return hl
+}
+
+// Accept returns the next HTTP/1.x connection
+func (hl *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-hl.conns:
+		return c, nil
+	case err := <-hl.errs:
+		return nil, err
+	case <-hl.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections
+func (hl *Listener) Close() error {
+	hl.closeOnce.Do(func() { close(hl.done) })
+	return hl.Listener.Close()
+}
+
+func (hl *Listener) acceptLoop() {
+	for {
+		conn, err := hl.Listener.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			hl.errs <- err
+			return
+		}
+		// Handshakes and sniffing run per connection so a slow client cannot block accept
+		go hl.dispatch(conn)
+	}
+}
+
+func (hl *Listener) dispatch(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return
+		}
+		// Lift the handshake deadlines; the servers set their own per request
+		tlsConn.SetReadDeadline(time.Time{})
+		tlsConn.SetWriteDeadline(time.Time{})
+		if tlsConn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+			hl.serveHTTP2(conn)
+			return
+		}
+	} else if hl.h2c {
+		br := bufio.NewReader(conn)
+		conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+		preface := hasPreface(br)
+		conn.SetReadDeadline(time.Time{})
+		conn = &bufferedConn{Conn: conn, reader: br}
+		if preface {
+			hl.serveHTTP2(conn)
+			return
+		}
+	}
+
+	select {
+	case hl.conns <- conn:
+	case <-hl.done:
+		conn.Close()
+	}
+}
+
+func (hl *Listener) serveHTTP2(conn net.Conn) {
+	if !hl.acquireIP(conn) {
+		conn.Close()
+		return
+	}
+	defer hl.releaseIP(conn)
+	hl.server.ServeConn(conn, &http2.ServeConnOpts{Handler: hl.handler, BaseConfig: hl.baseConfig})
+}
+
+// acquireIP counts an HTTP/2 connection against its client IP's limit
+func (hl *Listener) acquireIP(conn net.Conn) bool {
+	if hl.maxConnsPerIP <= 0 {
+		return true
+	}
+	ip := remoteIP(conn)
+	hl.perIPMu.Lock()
+	defer hl.perIPMu.Unlock()
+	if hl.perIP[ip] >= hl.maxConnsPerIP {
+		return false
+	}
+	hl.perIP[ip]++
+	return true
+}
+
+func (hl *Listener) releaseIP(conn net.Conn) {
+	if hl.maxConnsPerIP <= 0 {
+		return
+	}
+	ip := remoteIP(conn)
+	hl.perIPMu.Lock()
+	defer hl.perIPMu.Unlock()
+	if hl.perIP[ip]--; hl.perIP[ip] <= 0 {
+		delete(hl.perIP, ip)
+	}
+}
+
+func remoteIP(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return conn.RemoteAddr().String()
+}
+
+// hasPreface reports whether br starts with the HTTP/2 client preface. It
+// reads no further than the first byte that differs, so a short HTTP/1.x
+// request is not held waiting for bytes that will never come.
+func hasPreface(br *bufio.Reader) bool {
+	for n := 1; n <= len(clientPreface); n++ {
+		peek, err := br.Peek(n)
+		if err != nil || peek[n-1] != clientPreface[n-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// bufferedConn replays bytes that were peeked while sniffing the protocol
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// NetConn returns the sniffed connection
+func (c *bufferedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Handler runs a fasthttp handler for each HTTP/2 stream, so every request
+// on a multiplexed connection is balanced on its own
+func Handler(h fasthttp.RequestHandler, limits Limits) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// gRPC calls are long-lived message streams that are never buffered,
+		// so only other requests are held to the body limit
+		grpc := strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+		var body io.Reader = r.Body
+		if limits.MaxBodySize != nil && !grpc {
+			if limit := limits.MaxBodySize(r.URL.Path); limit > 0 {
+				if r.ContentLength > int64(limit) {
+					http.Error(w, "Request body too large", fasthttp.StatusRequestEntityTooLarge)
+					return
+				}
+				body = &limitedBody{r: r.Body, remaining: int64(limit)}
+			}
+		}
+
+		var req fasthttp.Request
+		req.Header.SetMethod(r.Method)
+		req.SetRequestURI(r.URL.RequestURI())
+		req.Header.SetHost(r.Host)
+		for k, vs := range r.Header {
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+
+		remoteAddr, _ := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+		var ctx fasthttp.RequestCtx
+		ctx.Init(&req, remoteAddr, nil)
+		ctx.SetUserValue(userValueHTTP2, true)
+		if r.ContentLength != 0 {
+			if limits.StreamRequestBody || grpc {
+				ctx.Request.SetBodyStream(body, int(r.ContentLength))
+			} else {
+				// Read up front like HTTP/1.1 bodies, so an oversized one is
+				// refused before anything is sent upstream
+				b, err := io.ReadAll(body)
+				if errors.Is(err, fasthttp.ErrBodyTooLarge) {
+					http.Error(w, "Request body too large", fasthttp.StatusRequestEntityTooLarge)
+					return
+				} else if err != nil {
+					http.Error(w, "Error when reading request body", fasthttp.StatusBadRequest)
+					return
+				}
+				ctx.Request.SetBody(b)
+			}
+		}
+
+		h(&ctx)
+		trailers, _ := ctx.UserValue(userValueTrailers).(func() http.Header)
+		writeResponse(w, &ctx.Response, trailers)
+	})
+}
+
+// limitedBody fails with fasthttp.ErrBodyTooLarge once more than the limit is read
+type limitedBody struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, fasthttp.ErrBodyTooLarge
+	}
+	// Read one byte past the limit to tell a body that fits exactly from one that does not
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), fasthttp.ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// writeResponse copies a fasthttp response to an HTTP/2 stream, dropping
+// connection-specific headers that HTTP/2 forbids
+func writeResponse(w http.ResponseWriter, resp *fasthttp.Response, trailers func() http.Header) {
+	header := w.Header()
+	resp.Header.VisitAll(func(k, v []byte) {
+		switch string(k) {
+		case fasthttp.HeaderConnection, fasthttp.HeaderTransferEncoding, "Keep-Alive", fasthttp.HeaderUpgrade:
+			return
+		}
+		header.Add(string(k), string(v))
+	})
+	w.WriteHeader(resp.StatusCode())
+
+	if !resp.IsBodyStream() {
+		w.Write(resp.Body())
+	} else {
+		// Flush as the stream is written so server-sent events are not held back
+		resp.BodyWriteTo(&flushWriter{w: w})
+		resp.CloseBodyStream()
+	}
+
+	if trailers != nil {
+		for k, vs := range trailers() {
+			for _, v := range vs {
+				header.Add(http.TrailerPrefix+k, v)
+			}
+		}
+	}
+}
+
+type flushWriter struct {
+	w io.Writer
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if f, ok := fw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
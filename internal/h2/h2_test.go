@@ -0,0 +1,111 @@
+package h2
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/minrate"
+	"github.com/valyala/fasthttp"
+)
+
+func TestShortHTTP1RequestIsNotHeldForThePreface(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hl := NewListener(ln, func(*fasthttp.RequestCtx) {}, true, Limits{})
+	defer hl.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Shorter than the preface, and the client waits for the response
+	io.WriteString(conn, "GET / HTTP/1.0\r\n\r\n")
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if c, err := hl.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+	select {
+	case c := <-accepted:
+		defer c.Close()
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		if line != "GET / HTTP/1.0\r\n" {
+			t.Errorf("replayed request line = %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("HTTP/1.0 request held waiting for the HTTP/2 preface")
+	}
+}
+
+// selfSigned returns a certificate for 127.0.0.1
+func selfSigned(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestSlowTLSClientIsStillRateChecked(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}, NextProtos: []string{"h2", "http/1.1"}}
+	hl := NewListener(tls.NewListener(minrate.NewListener(ln, 1000), cfg), func(*fasthttp.RequestCtx) {}, false, Limits{})
+	defer hl.Close()
+	go (&fasthttp.Server{Handler: func(*fasthttp.RequestCtx) {}}).Serve(hl)
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// A header trickling in far below 1000 bytes per second
+	start := time.Now()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nX-Slow: ")
+	go func() {
+		for {
+			time.Sleep(200 * time.Millisecond)
+			if _, err := io.WriteString(conn, "a"); err != nil {
+				return
+			}
+		}
+	}()
+
+	// fasthttp may answer with an error before closing; either way the connection ends
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Fatalf("slow client still connected after %v", time.Since(start).Round(time.Second))
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package headers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const headerRequestID = "X-Request-ID"
+
+// Vars are the values available to header templates as ${name}
+type Vars struct {
+	ClientIP  string
+	RequestID string
+	Backend   string // Empty when the response came from the cache
+	Route     string // Matched path prefix
+	Pool      string
+	Host      string
+	Method    string
+	Path      string
+	Time      time.Time
+}
+
+var variables = map[string]func(*Vars) string{
+	"client_ip":  func(v *Vars) string { return v.ClientIP },
+	"request_id": func(v *Vars) string { return v.RequestID },
+	"backend":    func(v *Vars) string { return v.Backend },
+	"route":      func(v *Vars) string { return v.Route },
+	"pool":       func(v *Vars) string { return v.Pool },
+	"host":       func(v *Vars) string { return v.Host },
+	"method":     func(v *Vars) string { return v.Method },
+	"path":       func(v *Vars) string { return v.Path },
+	"time":       func(v *Vars) string { return v.Time.UTC().Format(time.RFC3339) },
+	"time_unix":  func(v *Vars) string { return strconv.FormatInt(v.Time.Unix(), 10) },
+}
+
+// Presets are named groups of response headers
+var Presets = map[string]map[string]string{
+	"hsts": {
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+	},
+	"security": {
+		"X-Content-Type-Options": "nosniff",
+		"X-Frame-Options":        "SAMEORIGIN",
+		"Referrer-Policy":        "strict-origin-when-cross-origin",
+	},
+}
+
+// template is a header value with ${name} variables
+type template struct {
+	literals []string // One more than vars
+	vars     []func(*Vars) string
+}
+
+func compile(value string) (*template, error) {
+	t := &template{}
+	for {
+		start := strings.Index(value, "${")
+		if start < 0 {
+			t.literals = append(t.literals, value)
+			return t, nil
+		}
+		end := strings.Index(value[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated variable in %q", value)
+		}
+		name := value[start+2 : start+end]
+		fn, ok := variables[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown variable ${%s}", name)
+		}
+		t.literals = append(t.literals, value[:start])
+		t.vars = append(t.vars, fn)
+		value = value[start+end+1:]
+	}
+}
+
+func (t *template) expand(v *Vars) string {
+	if len(t.vars) == 0 {
+		return t.literals[0]
+	}
+	var b strings.Builder
+	for i, fn := range t.vars {
+		b.WriteString(t.literals[i])
+		b.WriteString(fn(v))
+	}
+	b.WriteString(t.literals[len(t.vars)])
+	return b.String()
+}
+
+type field struct {
+	name  string
+	value *template
+}
+
+// Rules remove, then set, then add headers. A value that expands to nothing,
+// such as ${backend} on a cached response, is skipped.
+type Rules struct {
+	remove []string
+	set    []field
+	add    []field
+}
+
+// NewRules compiles header edits. Preset headers are set before the explicit
+// ones, which can override them.
+func NewRules(add, set map[string]string, remove, presets []string) (*Rules, error) {
+	r := &Rules{remove: remove}
+
+	merged := map[string]string{}
+	for _, name := range presets {
+		preset, ok := Presets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown header preset %q", name)
+		}
+		for k, v := range preset {
+			merged[k] = v
+		}
+	}
+	for k, v := range set {
+		merged[k] = v
+	}
+
+	var err error
+	if r.set, err = compileFields(merged); err != nil {
+		return nil, err
+	}
+	if r.add, err = compileFields(add); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// compileFields compiles values in name order so edits apply predictably
+func compileFields(values map[string]string) ([]field, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make([]field, 0, len(names))
+	for _, name := range names {
+		t, err := compile(values[name])
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", name, err)
+		}
+		fields = append(fields, field{name: name, value: t})
+	}
+	return fields, nil
+}
+
+// headerEditor is implemented by both request and response headers
+type headerEditor interface {
+	Del(key string)
+	Set(key, value string)
+	Add(key, value string)
+}
+
+func (r *Rules) apply(h headerEditor, v *Vars) {
+	if r == nil {
+		return
+	}
+	for _, name := range r.remove {
+		h.Del(name)
+	}
+	for _, f := range r.set {
+		if value := f.value.expand(v); value != "" {
+			h.Set(f.name, value)
+		}
+	}
+	for _, f := range r.add {
+		if value := f.value.expand(v); value != "" {
+			h.Add(f.name, value)
+		}
+	}
+}
+
+// ApplyRequest edits request headers before they are sent upstream
+func (r *Rules) ApplyRequest(h *fasthttp.RequestHeader, v *Vars) {
+	r.apply(h, v)
+}
+
+// ApplyResponse edits response headers before they are returned
+func (r *Rules) ApplyResponse(h *fasthttp.ResponseHeader, v *Vars) {
+	r.apply(h, v)
+}
+
+// RequestID returns the request's X-Request-ID, generating one if the client
+// sent none. A generated ID is also set on the request so the backend, and
+// any upstream fetch made from a copy of the request, see the same ID.
+func RequestID(ctx *fasthttp.RequestCtx) string {
+	if id := ctx.Request.Header.Peek(headerRequestID); len(id) > 0 {
+		return string(id)
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	ctx.Request.Header.Set(headerRequestID, id)
+	return id
+}
@@ -0,0 +1,111 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// SERVING in grpc.health.v1.HealthCheckResponse.ServingStatus
+const grpcServing = 1
+
+// newGRPCClient returns an HTTP/2 client: h2c for http:// and TLS for https:// backends
+func (hc *HealthChecker) newGRPCClient() *http.Client {
+	return &http.Client{
+		Timeout: defaultTimeout,
+		Transport: &grpcTransport{
+			h2: &http2.Transport{TLSClientConfig: hc.TLSConfig},
+			h2c: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, network, addr)
+				},
+			},
+		},
+	}
+}
+
+type grpcTransport struct {
+	h2, h2c *http2.Transport
+}
+
+func (t *grpcTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Scheme == "https" {
+		return t.h2.RoundTrip(r)
+	}
+	return t.h2c.RoundTrip(r)
+}
+
+// checkGRPC calls grpc.health.v1.Health/Check and expects SERVING
+func (hc *HealthChecker) checkGRPC(client *http.Client, backendURL string) bool {
+	var msg []byte
+	if hc.GRPCService != "" {
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendString(msg, hc.GRPCService)
+	}
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	frame = append(frame, msg...)
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(backendURL, "/")+"/grpc.health.v1.Health/Check", bytes.NewReader(frame))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	// Trailers-only responses carry the status in the headers
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "0" || len(body) < 5 {
+		return false
+	}
+
+	return servingStatus(body[5:]) == grpcServing
+}
+
+// servingStatus extracts field 1 of a HealthCheckResponse
+func servingStatus(b []byte) protowire.Number {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0
+		}
+		b = b[n:]
+		if num == 1 && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return 0
+			}
+			return protowire.Number(v)
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return 0
+		}
+		b = b[n:]
+	}
+	return 0
+}
@@ -1,7 +1,11 @@
 package health
 
 import (
+	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 )
@@ -15,10 +19,25 @@ type Backend struct {
 
 // HealthChecker maintains backend health status
 type HealthChecker struct {
-	Backends []*Backend
-	Interval time.Duration
+	Backends  []*Backend
+	Interval  time.Duration
+	TLSConfig *tls.Config // Used for https:// backends
+
+	Mode        string // ModeHTTP (default), ModeGRPC, ModeTCP or ModeNone
+	GRPCService string // Service name sent in grpc.health.v1 checks
+	ProxyHeader []byte // Written first on http and tcp check connections, e.g. a PROXY protocol LOCAL header
 }
 
+// Health check modes
+const (
+	ModeHTTP = "http" // GET /health must return 200
+	ModeGRPC = "grpc" // grpc.health.v1.Health/Check over HTTP/2
+	ModeTCP  = "tcp"  // A TCP connect must succeed
+	ModeNone = "none" // Backends are always considered alive, e.g. UDP services
+)
+
+const defaultTimeout = 2 * time.Second
+
 // NewHealthChecker initializes the health checker
 func NewHealthChecker(backendURLs []string, interval time.Duration) *HealthChecker {
 	backends := make([]*Backend, len(backendURLs))
@@ -31,20 +50,39 @@ func NewHealthChecker(backendURLs []string, interval time.Duration) *HealthCheck
 
 // CheckHealth verifies backend status and updates their availability
 func (hc *HealthChecker) CheckHealth() {
+	if hc.Mode == ModeNone {
+		return
+	}
+
+	client := &http.Client{Timeout: defaultTimeout}
+	if hc.TLSConfig != nil || hc.ProxyHeader != nil {
+		client.Transport = &http.Transport{TLSClientConfig: hc.TLSConfig, DialContext: hc.dial}
+	}
+	if hc.Mode == ModeGRPC {
+		client = hc.newGRPCClient()
+	}
+
 	for {
 		var wg sync.WaitGroup
 		for _, backend := range hc.Backends {
 			wg.Add(1)
 			go func(b *Backend) {
 				defer wg.Done()
-				client := &http.Client{Timeout: 2 * time.Second}
-				resp, err := client.Get(b.URL + "/health") // Expecting /health endpoint
-				b.mu.Lock()
-				if err == nil && resp.StatusCode == http.StatusOK {
-					b.Alive = true
-				} else {
-					b.Alive = false
+				alive := false
+				switch hc.Mode {
+				case ModeGRPC:
+					alive = hc.checkGRPC(client, b.URL)
+				case ModeTCP:
+					alive = hc.checkTCP(b.URL)
+				default:
+					resp, err := client.Get(b.URL + "/health") // Expecting /health endpoint
+					if err == nil {
+						resp.Body.Close()
+						alive = resp.StatusCode == http.StatusOK
+					}
 				}
+				b.mu.Lock()
+				b.Alive = alive
 				b.mu.Unlock()
 			}(backend)
 		}
@@ -53,6 +91,38 @@ func (hc *HealthChecker) CheckHealth() {
 	}
 }
 
+// checkTCP dials a tcp://host:port backend
+func (hc *HealthChecker) checkTCP(backendURL string) bool {
+	u, err := url.Parse(backendURL)
+	if err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	conn, err := hc.dial(ctx, "tcp", u.Host)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// dial connects to a backend and sends ProxyHeader, if set
+func (hc *HealthChecker) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil || hc.ProxyHeader == nil {
+		return conn, err
+	}
+	conn.SetWriteDeadline(time.Now().Add(defaultTimeout))
+	if _, err := conn.Write(hc.ProxyHeader); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetWriteDeadline(time.Time{})
+	return conn, nil
+}
+
 // GetHealthyBackends returns a list of available backends
 func (hc *HealthChecker) GetHealthyBackends() []string {
 	healthy := []string{}
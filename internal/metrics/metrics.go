@@ -26,6 +26,95 @@ var (
 		[]string{"method", "path"},
 	)
 
+	ProxyUserRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "leanbalancer_proxy_user_requests_total",
+			Help: "Forward proxy requests by authenticated user and result",
+		},
+		[]string{"user", "result"},
+	)
+
+	ProxyUserBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "leanbalancer_proxy_user_bytes_total",
+			Help: "Forward proxy bytes by authenticated user, sent upstream or downstream",
+		},
+		[]string{"user", "direction"},
+	)
+
+	CacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "leanbalancer_cache_requests_total",
+			Help: "Cacheable requests by result: hit, miss, stale, revalidated, coalesced or bypass",
+		},
+		[]string{"result"},
+	)
+
+	CacheBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "leanbalancer_cache_bytes",
+			Help: "Bytes held by the response cache per tier",
+		},
+		[]string{"tier"},
+	)
+
+	SplitRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "leanbalancer_split_requests_total",
+			Help: "Requests on split routes by the pool they were sent to",
+		},
+		[]string{"route", "pool"},
+	)
+
+	MirrorRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "leanbalancer_mirror_requests_total",
+			Help: "Mirrored requests by result: match, mismatch (status differs from the primary), error or dropped",
+		},
+		[]string{"route", "result"},
+	)
+
+	MirrorResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "leanbalancer_mirror_responses_total",
+			Help: "Status codes of mirrored requests, from the primary and the shadow pool",
+		},
+		[]string{"route", "side", "code"},
+	)
+
+	MirrorDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "leanbalancer_mirror_duration_seconds",
+			Help:    "Latency of mirrored requests, from the primary and the shadow pool",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"route", "side"},
+	)
+
+	HedgeRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "leanbalancer_hedge_requests_total",
+			Help: "Hedged requests by outcome: sent, won (the hedge answered first) or over_budget",
+		},
+		[]string{"route", "outcome"},
+	)
+
+	RateLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "leanbalancer_rate_limited_total",
+			Help: "Requests rejected by a route's rate limit",
+		},
+		[]string{"route"},
+	)
+
+	UDPWriteErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "leanbalancer_udp_write_errors_total",
+			Help: "Datagrams a UDP listener failed to send, to a backend or back to a client",
+		},
+		[]string{"listener", "direction"},
+	)
+
 	ActiveConnections = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "leanbalancer_active_connections",
@@ -36,7 +125,8 @@ var (
 
 // Register metrics with Prometheus
 func RegisterMetrics() {
-	prometheus.MustRegister(RequestsTotal, RequestDuration, ActiveConnections)
+	prometheus.MustRegister(RequestsTotal, RequestDuration, ActiveConnections, ProxyUserRequests, ProxyUserBytes, CacheRequests, CacheBytes, SplitRequests,
+		MirrorRequests, MirrorResponses, MirrorDuration, HedgeRequests, RateLimited, UDPWriteErrors)
 }
 
 // Metrics handler for Fasthttp
@@ -0,0 +1,117 @@
+package minrate
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// grace is how long any request may take to arrive before its rate counts,
+// so small requests and slow starts are not cut off
+const grace = 5 * time.Second
+
+// NewListener wraps ln so that a client sending a request slower than
+// bytesPerSecond times out. A request starts with its first byte and ends
+// when the response is written; idle keep-alive time is not counted, and
+// connections stop being checked once Hijacked is called for them.
+func NewListener(ln net.Listener, bytesPerSecond int) net.Listener {
+	return &listener{Listener: ln, rate: bytesPerSecond}
+}
+
+type listener struct {
+	net.Listener
+	rate int
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, rate: l.rate}, nil
+}
+
+type conn struct {
+	net.Conn
+	rate int
+
+	mu        sync.Mutex
+	receiving bool      // Between a request's first byte and its response
+	start     time.Time // First byte of the request
+	received  int
+	deadline  time.Time // Set by the server
+	hijacked  bool
+}
+
+// Read times out once the request falls behind the minimum rate, or at the
+// server's own deadline if that comes first
+func (c *conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	if c.receiving && !c.hijacked {
+		due := c.start.Add(grace + time.Duration(c.received)*time.Second/time.Duration(c.rate))
+		if deadline.IsZero() || due.Before(deadline) {
+			deadline = due
+		}
+	}
+	c.mu.Unlock()
+	if err := c.Conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		if !c.receiving {
+			c.receiving = true
+			c.start = time.Now()
+		}
+		c.received += n
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// Write ends the request being received
+func (c *conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.receiving = false
+	c.received = 0
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// Hijacked stops checking the rate of c, or of the connection from this
+// package that it wraps. Hijack handlers call it once the connection
+// becomes a tunnel, whose client may rightly stay quiet for a long time.
+func Hijacked(c net.Conn) {
+	for c != nil {
+		switch v := c.(type) {
+		case *conn:
+			v.mu.Lock()
+			v.hijacked = true
+			v.mu.Unlock()
+			return
+		case interface{ UnsafeConn() net.Conn }: // fasthttp's hijacked conn
+			c = v.UnsafeConn()
+		case interface{ NetConn() net.Conn }: // *tls.Conn
+			c = v.NetConn()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/cache"
+	"github.com/siddhu949/leanbalancer/internal/errorpages"
+	"github.com/siddhu949/leanbalancer/internal/metrics"
+	"github.com/siddhu949/leanbalancer/internal/rewrite"
+	"github.com/valyala/fasthttp"
+)
+
+// Response cache for routes with Cache set; nil when caching is disabled
+var responseCache *cache.Cache
+
+// Keys with a stale-while-revalidate refresh in flight
+var revalidating sync.Map
+
+// Concurrent misses for a key wait this long for the first request's upstream fetch
+var coalesceTimeout = 5 * time.Second
+
+// flight is an upstream fetch that later requests for the same key wait on
+type flight struct {
+	done chan struct{}
+
+	// The leader's response, set before done is closed, whether or not it
+	// was stored; nil if it cannot be shared
+	resp      *fasthttp.Response
+	vary      []string // Request headers the response varies on
+	varyValue string   // The leader's values for them
+	generated bool     // An error response of the proxy's own
+}
+
+var (
+	flightsMu sync.Mutex
+	flights   = map[string]*flight{}
+)
+
+// SetCache enables the response cache on routes that opt in
+func SetCache(c *cache.Cache) {
+	responseCache = c
+}
+
+// SetCoalesceTimeout sets how long concurrent misses wait for the first
+// request's fetch before going upstream themselves; 0 disables coalescing
+func SetCoalesceTimeout(d time.Duration) {
+	coalesceTimeout = d
+}
+
+// joinFlight returns the fetch in flight for key, or registers a new one
+// that the caller leads and must finish
+func joinFlight(key string) (*flight, bool) {
+	flightsMu.Lock()
+	defer flightsMu.Unlock()
+	if f, ok := flights[key]; ok {
+		return f, false
+	}
+	f := &flight{done: make(chan struct{})}
+	flights[key] = f
+	return f, true
+}
+
+func finishFlight(key string, f *flight) {
+	flightsMu.Lock()
+	delete(flights, key)
+	flightsMu.Unlock()
+	close(f.done)
+}
+
+// share keeps a copy of the leader's response for the waiters. Streamed
+// bodies, 304s to conditional requests and responses meant for one client
+// (private, setting cookies, Vary: *) are not shared.
+func (f *flight) share(ctx *fasthttp.RequestCtx) {
+	resp := &ctx.Response
+	if resp.IsBodyStream() || resp.StatusCode() == fasthttp.StatusNotModified {
+		return
+	}
+	if bytes.Contains(bytes.ToLower(resp.Header.Peek(fasthttp.HeaderCacheControl)), []byte("private")) {
+		return
+	}
+	hasCookie := false
+	resp.Header.VisitAllCookie(func(_, _ []byte) { hasCookie = true })
+	if hasCookie {
+		return
+	}
+	for _, name := range strings.Split(string(resp.Header.Peek(fasthttp.HeaderVary)), ",") {
+		if name = strings.TrimSpace(name); name == "*" {
+			return
+		} else if name != "" {
+			f.vary = append(f.vary, name)
+		}
+	}
+	f.varyValue = varyValue(f.vary, &ctx.Request.Header)
+	f.resp = &fasthttp.Response{}
+	resp.CopyTo(f.resp)
+	f.generated, _ = ctx.UserValue(errorpages.UserValue).(bool)
+}
+
+// varyValue joins the values of the named request headers
+func varyValue(names []string, h *fasthttp.RequestHeader) string {
+	var b strings.Builder
+	for _, name := range names {
+		b.Write(h.Peek(name))
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// waitFlight waits for the leader's fetch, then serves what it stored, or
+// else the response it got. It returns false, and the caller fetches
+// itself, if the leader is slow or its response could not be shared.
+func waitFlight(ctx *fasthttp.RequestCtx, key string, f *flight) bool {
+	timer := time.NewTimer(coalesceTimeout)
+	defer timer.Stop()
+	select {
+	case <-f.done:
+	case <-timer.C:
+		return false
+	}
+
+	if e := responseCache.Get(key, &ctx.Request.Header); e != nil && e.Fresh(time.Now()) {
+		serveEntry(ctx, e, "COALESCED")
+		return true
+	}
+	if f.resp != nil && varyValue(f.vary, &ctx.Request.Header) == f.varyValue {
+		metrics.CacheRequests.WithLabelValues("coalesced").Inc()
+		f.resp.CopyTo(&ctx.Response)
+		ctx.Response.Header.Set("X-Cache", "COALESCED")
+		if f.generated {
+			ctx.SetUserValue(errorpages.UserValue, true)
+		}
+		return true
+	}
+	return false
+}
+
+// cacheKey is the request's host, path and query, e.g. "shop.example.com/api/items?page=2"
+func cacheKey(ctx *fasthttp.RequestCtx) string {
+	return string(ctx.Host()) + string(ctx.RequestURI())
+}
+
+// isCacheableRequest reports whether the request may be answered from the cache
+func isCacheableRequest(ctx *fasthttp.RequestCtx) bool {
+	if !ctx.IsGet() && !ctx.IsHead() {
+		return false
+	}
+	if isUpgradeRequest(ctx) || len(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)) > 0 {
+		return false
+	}
+	return !cache.RequestNoStore(&ctx.Request.Header)
+}
+
+// isUpstreamError reports whether stale-if-error may replace the response
+func isUpstreamError(status int) bool {
+	switch status {
+	case fasthttp.StatusInternalServerError, fasthttp.StatusBadGateway,
+		fasthttp.StatusServiceUnavailable, fasthttp.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// cachedProxy answers from the response cache when it can, revalidating
+// stale entries with the pool and storing cacheable responses
+func cachedProxy(ctx *fasthttp.RequestCtx, route *Route, target *rewrite.Result) {
+	if !isCacheableRequest(ctx) {
+		metrics.CacheRequests.WithLabelValues("bypass").Inc()
+		proxyToPool(ctx, route.Pool, target, route)
+		return
+	}
+
+	key := cacheKey(ctx)
+	now := time.Now()
+	entry := responseCache.Get(key, &ctx.Request.Header)
+
+	if entry != nil && !cache.RequestNoCache(&ctx.Request.Header) {
+		if entry.Fresh(now) {
+			serveEntry(ctx, entry, "HIT")
+			return
+		}
+		if entry.Stale(now, entry.StaleWhileRevalidate) {
+			serveEntry(ctx, entry, "STALE")
+			revalidateInBackground(ctx, route, target, key, entry)
+			return
+		}
+	}
+
+	// Only one GET per key goes upstream at a time; the rest wait for its entry
+	if ctx.IsGet() && coalesceTimeout > 0 {
+		f, leader := joinFlight(key)
+		if leader {
+			defer func() {
+				f.share(ctx)
+				finishFlight(key, f)
+			}()
+		} else if waitFlight(ctx, key, f) {
+			return
+		}
+	}
+
+	upstream := fetchUpstream(newUpstreamRequest(ctx, entry), ctx.RemoteAddr(), route, target)
+	ctx.SetUserValue(backendUserValue, upstream.UserValue(backendUserValue))
+	ctx.SetUserValue(errorpages.UserValue, upstream.UserValue(errorpages.UserValue))
+	resp := &upstream.Response
+	now = time.Now()
+
+	switch {
+	case entry != nil && resp.StatusCode() == fasthttp.StatusNotModified:
+		if refreshed, ok := responseCache.Revalidated(entry, &resp.Header, now); ok {
+			responseCache.Set(key, &ctx.Request.Header, refreshed)
+			entry = refreshed
+		}
+		serveEntry(ctx, entry, "REVALIDATED")
+
+	case entry != nil && isUpstreamError(resp.StatusCode()) && entry.Stale(now, entry.StaleIfError):
+		serveEntry(ctx, entry, "STALE")
+
+	default:
+		if ctx.IsGet() && !resp.IsBodyStream() {
+			if e, ok := responseCache.NewEntry(key, resp, now); ok {
+				responseCache.Set(key, &ctx.Request.Header, e)
+				serveEntry(ctx, e, "MISS")
+				return
+			}
+		}
+		metrics.CacheRequests.WithLabelValues("miss").Inc()
+		copyUpstreamResponse(ctx, upstream)
+		ctx.Response.Header.Set("X-Cache", "MISS")
+	}
+}
+
+// serveEntry writes a stored response, or 304 if the client already has it
+func serveEntry(ctx *fasthttp.RequestCtx, e *cache.Entry, result string) {
+	metrics.CacheRequests.WithLabelValues(strings.ToLower(result)).Inc()
+	e.WriteTo(&ctx.Response, time.Now())
+	if e.NotModified(&ctx.Request.Header) {
+		ctx.Response.SetStatusCode(fasthttp.StatusNotModified)
+		ctx.Response.ResetBody()
+	}
+	ctx.Response.Header.Set("X-Cache", result)
+}
+
+// newUpstreamRequest copies the client request, replacing its validators
+// with those of the stored entry so the backend can answer 304
+func newUpstreamRequest(ctx *fasthttp.RequestCtx, entry *cache.Entry) *fasthttp.Request {
+	req := &fasthttp.Request{}
+	ctx.Request.Header.CopyTo(&req.Header)
+	req.Header.Del(fasthttp.HeaderIfNoneMatch)
+	req.Header.Del(fasthttp.HeaderIfModifiedSince)
+	if entry != nil {
+		if entry.ETag != "" {
+			req.Header.Set(fasthttp.HeaderIfNoneMatch, entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set(fasthttp.HeaderIfModifiedSince, entry.LastModified)
+		}
+	}
+	return req
+}
+
+// fetchUpstream proxies req to the pool in a detached context so the
+// response can be inspected before it reaches the client
+func fetchUpstream(req *fasthttp.Request, remoteAddr net.Addr, route *Route, target *rewrite.Result) *fasthttp.RequestCtx {
+	upstream := &fasthttp.RequestCtx{}
+	upstream.Init(req, remoteAddr, nil)
+	proxyToPool(upstream, route.Pool, target, route)
+	return upstream
+}
+
+// copyUpstreamResponse hands a fetched response to the client, including streamed bodies
+func copyUpstreamResponse(ctx *fasthttp.RequestCtx, upstream *fasthttp.RequestCtx) {
+	if upstream.Response.IsBodyStream() {
+		upstream.Response.Header.CopyTo(&ctx.Response.Header)
+		ctx.Response.SetBodyStream(upstream.Response.BodyStream(), upstream.Response.Header.ContentLength())
+		return
+	}
+	upstream.Response.CopyTo(&ctx.Response)
+}
+
+// revalidateInBackground refreshes a stale entry after it has been served
+func revalidateInBackground(ctx *fasthttp.RequestCtx, route *Route, target *rewrite.Result, key string, entry *cache.Entry) {
+	if _, busy := revalidating.LoadOrStore(key, true); busy {
+		return
+	}
+	req := newUpstreamRequest(ctx, entry)
+	remoteAddr := ctx.RemoteAddr()
+
+	go func() {
+		defer revalidating.Delete(key)
+		upstream := fetchUpstream(req, remoteAddr, route, target)
+		resp := &upstream.Response
+		defer resp.CloseBodyStream()
+
+		now := time.Now()
+		if resp.StatusCode() == fasthttp.StatusNotModified {
+			if refreshed, ok := responseCache.Revalidated(entry, &resp.Header, now); ok {
+				responseCache.Set(key, &req.Header, refreshed)
+			}
+			return
+		}
+		if req.Header.IsGet() && !resp.IsBodyStream() {
+			if e, ok := responseCache.NewEntry(key, resp, now); ok {
+				responseCache.Set(key, &req.Header, e)
+			}
+		}
+	}()
+}
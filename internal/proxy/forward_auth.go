@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/siddhu949/leanbalancer/internal/egress"
+	"github.com/siddhu949/leanbalancer/internal/metrics"
+	"github.com/siddhu949/leanbalancer/internal/proxyauth"
+	"github.com/valyala/fasthttp"
+)
+
+// Forward proxy users; nil when authentication is disabled
+var forwardAuth *proxyauth.Authenticator
+
+// SetForwardProxyAuth requires Proxy-Authorization on forward proxy requests
+func SetForwardProxyAuth(a *proxyauth.Authenticator) {
+	forwardAuth = a
+}
+
+// authorizeForward authenticates the client and applies the user's
+// destination allowlist and rate limit. It writes the error response and
+// returns false when the request must not be forwarded.
+func authorizeForward(ctx *fasthttp.RequestCtx, clientIP, method, target, host string) (string, bool) {
+	if forwardAuth == nil {
+		return "", true
+	}
+
+	user, err := forwardAuth.Authenticate(ctx.Request.Header.Peek(fasthttp.HeaderProxyAuthorization))
+	if err != nil {
+		log.Printf("%s [%s] %s rejected: %s", clientIP, method, target, err)
+		ctx.Error(err.Error(), fasthttp.StatusProxyAuthRequired)
+		ctx.Response.Header.Add(fasthttp.HeaderProxyAuthenticate, fmt.Sprintf("Basic realm=%q", forwardAuth.Realm))
+		ctx.Response.Header.Add(fasthttp.HeaderProxyAuthenticate, fmt.Sprintf("Bearer realm=%q", forwardAuth.Realm))
+		return "", false
+	}
+
+	if len(user.AllowedHosts) > 0 && !egress.MatchHost(user.AllowedHosts, host) {
+		log.Printf("%s [%s] %s denied for user %s: host not in allowlist", clientIP, method, target, user.Name)
+		metrics.ProxyUserRequests.WithLabelValues(user.Name, "denied").Inc()
+		ctx.Error(fmt.Sprintf("Destination %s is not allowed for %s", host, user.Name), fasthttp.StatusForbidden)
+		return "", false
+	}
+
+	if user.Limiter != nil {
+		if r := user.Limiter.Allow(user.Name); !r.Allowed {
+			metrics.ProxyUserRequests.WithLabelValues(user.Name, "rate_limited").Inc()
+			ctx.Error("Rate limit exceeded", fasthttp.StatusTooManyRequests)
+			ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(r.RetryAfter.Seconds()))))
+			return "", false
+		}
+	}
+
+	metrics.ProxyUserRequests.WithLabelValues(user.Name, "allowed").Inc()
+	return user.Name, true
+}
+
+// recordUsage attributes forwarded bytes to an authenticated user
+func recordUsage(user string, upstream, downstream int64) {
+	if user == "" {
+		return
+	}
+	if upstream > 0 {
+		metrics.ProxyUserBytes.WithLabelValues(user, "upstream").Add(float64(upstream))
+	}
+	if downstream > 0 {
+		metrics.ProxyUserBytes.WithLabelValues(user, "downstream").Add(float64(downstream))
+	}
+}
+
+// countingConn counts the bytes read from and written to a connection
+type countingConn struct {
+	net.Conn
+	read, written atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
@@ -1,42 +1,184 @@
 package proxy
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/siddhu949/leanbalancer/internal/egress"
+	"github.com/siddhu949/leanbalancer/internal/h2"
+	"github.com/siddhu949/leanbalancer/internal/minrate"
+	"github.com/siddhu949/leanbalancer/internal/realip"
 	"github.com/siddhu949/leanbalancer/pkg/pool"
 	"github.com/siddhu949/leanbalancer/pkg/utils"
 	"github.com/valyala/fasthttp"
 )
 
+var (
+	// Standard proxy requests (absolute-form URIs and CONNECT) are only
+	// accepted once enabled; /forward?target= is always available
+	forwardProxyEnabled = false
+
+	forwardConnectTimeout = 5 * time.Second
+	forwardTimeout        = 30 * time.Second
+	forwardIdleTimeout    = 5 * time.Minute
+
+	forwardClients = pool.NewClientPoolWithDial(nil, dialUpstream)
+)
+
+// SetForwardProxy enables standard forward-proxy requests and sets the
+// upstream connect, response and CONNECT tunnel idle timeouts
+func SetForwardProxy(enabled bool, connectTimeout, timeout, idleTimeout time.Duration) {
+	forwardProxyEnabled = enabled
+	forwardConnectTimeout = connectTimeout
+	forwardTimeout = timeout
+	forwardIdleTimeout = idleTimeout
+}
+
+// IsForwardProxyRequest reports whether the request is addressed to the
+// proxy itself (CONNECT or an absolute-form URI) rather than to a route
+func IsForwardProxyRequest(ctx *fasthttp.RequestCtx) bool {
+	if !forwardProxyEnabled || h2.IsHTTP2(ctx) {
+		return false
+	}
+	return ctx.IsConnect() || isAbsoluteURI(ctx.Request.Header.RequestURI())
+}
+
+func isAbsoluteURI(uri []byte) bool {
+	return bytes.HasPrefix(uri, []byte("http://")) || bytes.HasPrefix(uri, []byte("https://"))
+}
+
+// dialUpstream opens a connection to a forward-proxy destination permitted by the egress policy
+func dialUpstream(addr string) (net.Conn, error) {
+	return egress.Dial(addr, forwardConnectTimeout)
+}
+
+// upstreamErrorStatus maps a failed upstream request to the client's status,
+// logging egress policy denials with their reason
+func upstreamErrorStatus(err error, clientIP, method, target string) int {
+	if denied, ok := egress.IsDenied(err); ok {
+		log.Printf("%s [%s] %s denied by egress policy: %s", clientIP, method, target, denied.Reason)
+		return fasthttp.StatusForbidden
+	}
+	var netErr net.Error
+	if errors.Is(err, fasthttp.ErrTimeout) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fasthttp.StatusGatewayTimeout
+	}
+	return fasthttp.StatusBadGateway
+}
+
 // Forward Proxy Handler
 func ForwardProxyHandler(ctx *fasthttp.RequestCtx) {
+	if ctx.IsConnect() {
+		connectHandler(ctx)
+		return
+	}
+
 	startTime := time.Now()
 
 	target := string(ctx.QueryArgs().Peek("target"))
+	if uri := ctx.Request.Header.RequestURI(); forwardProxyEnabled && isAbsoluteURI(uri) {
+		target = string(uri)
+	}
 	if target == "" {
 		ctx.Error("Missing target parameter", fasthttp.StatusBadRequest)
 		return
 	}
+	targetURL, err := url.Parse(target)
+	if err != nil || targetURL.Host == "" {
+		ctx.Error("Invalid target URL", fasthttp.StatusBadRequest)
+		return
+	}
+
+	clientIP := realip.ClientIP(ctx)
+	user, ok := authorizeForward(ctx, clientIP, string(ctx.Method()), target, strings.ToLower(targetURL.Hostname()))
+	if !ok {
+		return
+	}
 
-	client := pool.GetClient()
-	defer pool.ReleaseClient(client)
+	client := forwardClients.Get()
+	defer forwardClients.Release(client)
 
+	streaming := pool.StreamingEnabled()
 	req := fasthttp.AcquireRequest()
-	resp := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(resp)
 
-	ctx.Request.CopyTo(req)
+	sent := prepareRequest(ctx, req, streaming)
+	for header := range hopHeaders {
+		req.Header.Del(header)
+	}
+	req.Header.Del(fasthttp.HeaderProxyAuthorization)
 	req.SetRequestURI(target)
 
-	err := client.DoTimeout(req, resp, 3*time.Second)
+	resp := fasthttp.AcquireResponse()
+	if streaming {
+		err = client.Do(req, resp)
+	} else {
+		err = client.DoTimeout(req, resp, forwardTimeout)
+	}
 	if err != nil {
-		ctx.Error(fmt.Sprintf("Error forwarding request: %s", err), fasthttp.StatusServiceUnavailable)
+		fasthttp.ReleaseResponse(resp)
+		status := upstreamErrorStatus(err, clientIP, string(ctx.Method()), target)
+		utils.LogRequest(clientIP, string(ctx.Method()), target, status, time.Since(startTime))
+		ctx.Error(fmt.Sprintf("Error forwarding request: %s", err), status)
 		return
 	}
 
-	utils.LogRequest(string(ctx.Method()), target, resp.StatusCode(), time.Since(startTime))
+	utils.LogRequest(clientIP, string(ctx.Method()), target, resp.StatusCode(), time.Since(startTime))
 
+	if streaming {
+		// Content lengths are -1 on chunked bodies, so count what is streamed
+		upstream := sent.Count()
+		streamResponse(ctx, resp, func(downstream int64) {
+			recordUsage(user, upstream, downstream)
+		})
+		return
+	}
+	recordUsage(user, int64(len(req.Body())), int64(len(resp.Body())))
 	resp.CopyTo(&ctx.Response)
+	fasthttp.ReleaseResponse(resp)
+}
+
+// connectHandler opens a tunnel to the host:port named by a CONNECT request
+func connectHandler(ctx *fasthttp.RequestCtx) {
+	start := time.Now()
+	clientIP := realip.ClientIP(ctx)
+	target := string(ctx.Request.Header.RequestURI())
+
+	if _, port, err := net.SplitHostPort(target); err != nil || port == "" {
+		utils.LogRequest(clientIP, "CONNECT", target, fasthttp.StatusBadRequest, time.Since(start))
+		ctx.Error("CONNECT target must be host:port", fasthttp.StatusBadRequest)
+		return
+	}
+	host, _, _ := net.SplitHostPort(target)
+	user, ok := authorizeForward(ctx, clientIP, "CONNECT", target, strings.ToLower(host))
+	if !ok {
+		return
+	}
+
+	conn, err := dialUpstream(target)
+	if err != nil {
+		status := upstreamErrorStatus(err, clientIP, "CONNECT", target)
+		utils.LogRequest(clientIP, "CONNECT", target, status, time.Since(start))
+		ctx.Error(fmt.Sprintf("Error connecting to %s: %s", target, err), status)
+		return
+	}
+
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(clientConn net.Conn) {
+		minrate.Hijacked(clientConn)
+		if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+			conn.Close()
+			return
+		}
+		counted := &countingConn{Conn: clientConn}
+		splice(counted, conn, forwardIdleTimeout)
+		recordUsage(user, counted.read.Load(), counted.written.Load())
+		utils.LogRequest(clientIP, "CONNECT", target, fasthttp.StatusOK, time.Since(start))
+	})
 }
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/h2"
+	"github.com/siddhu949/leanbalancer/internal/realip"
+	"github.com/siddhu949/leanbalancer/pkg/algorithm"
+	"github.com/siddhu949/leanbalancer/pkg/utils"
+	"github.com/valyala/fasthttp"
+)
+
+// gRPC status codes used when the proxy itself fails the call
+const (
+	grpcUnknown          = 2
+	grpcDeadlineExceeded = 4
+	grpcPermissionDenied = 7
+	grpcUnimplemented    = 12
+	grpcInternal         = 13
+	grpcUnavailable      = 14
+	grpcUnauthenticated  = 16
+)
+
+// isGRPCRequest reports whether the request carries a gRPC content type
+func isGRPCRequest(ctx *fasthttp.RequestCtx) bool {
+	return strings.HasPrefix(string(ctx.Request.Header.ContentType()), "application/grpc")
+}
+
+// grpcHandler proxies one RPC. Every call is balanced on its own, so a single
+// long-lived client connection is still spread across the whole pool.
+func grpcHandler(ctx *fasthttp.RequestCtx, p *Pool) {
+	start := time.Now()
+	clientIP := realip.ClientIP(ctx)
+
+	if !h2.IsHTTP2(ctx) || !isGRPCRequest(ctx) {
+		ctx.Error("gRPC routes require HTTP/2 with application/grpc", fasthttp.StatusUnsupportedMediaType)
+		return
+	}
+
+	backend := p.Balancer.GetNextBackend(clientIP)
+	if backend == nil {
+		grpcError(ctx, grpcUnavailable, "no available backends")
+		return
+	}
+	tracker, _ := p.Balancer.(algorithm.ConnectionTracker)
+
+	target := backend.String() + string(ctx.Path())
+	req, err := http.NewRequest(string(ctx.Method()), target, ctx.RequestBodyStream())
+	if err != nil {
+		releaseBackend(tracker, backend)
+		grpcError(ctx, grpcInternal, err.Error())
+		return
+	}
+	req.ContentLength = -1
+	req.Host = backend.Host
+	ctx.Request.Header.VisitAll(func(k, v []byte) {
+		if !hopHeaders[string(k)] {
+			req.Header.Add(string(k), string(v))
+		}
+	})
+
+	resp, err := p.HTTP2.RoundTrip(req)
+	if err != nil {
+		releaseBackend(tracker, backend)
+		code := grpcUnavailable
+		if errors.Is(err, context.DeadlineExceeded) {
+			code = grpcDeadlineExceeded
+		}
+		grpcError(ctx, code, err.Error())
+		utils.LogRequest(clientIP, "GRPC", string(ctx.Path()), fasthttp.StatusServiceUnavailable, time.Since(start))
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		releaseBackend(tracker, backend)
+		grpcError(ctx, grpcCodeFromHTTP(resp.StatusCode), "upstream returned HTTP "+strconv.Itoa(resp.StatusCode))
+		utils.LogRequest(clientIP, "GRPC", string(ctx.Path()), resp.StatusCode, time.Since(start))
+		return
+	}
+
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			ctx.Response.Header.Add(k, v)
+		}
+	}
+
+	body := &grpcBody{ReadCloser: resp.Body, done: func() { releaseBackend(tracker, backend) }}
+	ctx.Response.SetBodyStream(body, -1)
+	h2.SetTrailers(ctx, func() http.Header {
+		trailer := resp.Trailer.Clone()
+		if trailer == nil {
+			trailer = http.Header{}
+		}
+		// A stream that breaks before the backend's trailers still needs a status
+		if trailer.Get("Grpc-Status") == "" && resp.Header.Get("Grpc-Status") == "" {
+			trailer.Set("Grpc-Status", strconv.Itoa(grpcUnavailable))
+			msg := "upstream closed the stream without a status"
+			if err := body.Err(); err != nil {
+				msg = err.Error()
+			}
+			trailer.Set("Grpc-Message", url.PathEscape(msg))
+		}
+		return trailer
+	})
+	utils.LogRequest(clientIP, "GRPC", string(ctx.Path()), resp.StatusCode, time.Since(start))
+}
+
+func releaseBackend(tracker algorithm.ConnectionTracker, backend *url.URL) {
+	if tracker != nil {
+		tracker.Release(backend)
+	}
+}
+
+// grpcError answers with a trailers-only gRPC response
+func grpcError(ctx *fasthttp.RequestCtx, code int, message string) {
+	ctx.Response.Reset()
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/grpc")
+	ctx.Response.Header.Set("Grpc-Status", strconv.Itoa(code))
+	ctx.Response.Header.Set("Grpc-Message", url.PathEscape(message))
+}
+
+// grpcCodeFromHTTP maps an upstream HTTP status to a gRPC code (per the gRPC HTTP/2 spec)
+func grpcCodeFromHTTP(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcInternal
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return grpcUnavailable
+	default:
+		return grpcUnknown
+	}
+}
+
+// grpcBody records read errors and releases the backend when the stream ends
+type grpcBody struct {
+	io.ReadCloser
+	done func()
+
+	mu   sync.Mutex
+	err  error
+	once sync.Once
+}
+
+func (b *grpcBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.mu.Lock()
+		b.err = err
+		b.mu.Unlock()
+	}
+	return n, err
+}
+
+func (b *grpcBody) Close() error {
+	b.once.Do(b.done)
+	return b.ReadCloser.Close()
+}
+
+// Err returns the error that ended the stream, if any
+func (b *grpcBody) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
@@ -0,0 +1,331 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/metrics"
+	"github.com/siddhu949/leanbalancer/internal/rewrite"
+	"github.com/siddhu949/leanbalancer/pkg/algorithm"
+	"github.com/valyala/fasthttp"
+)
+
+// Hedge sends a second copy of a slow GET, HEAD or OPTIONS request to
+// another backend and uses whichever answers first. fasthttp can't abort a
+// request in flight, so each copy has a connection to itself and the slower
+// one is cancelled by closing it.
+//
+// The delay is fixed, or with Percentile set, the route's recent latency at
+// that percentile. Latencies are measured per route rather than per pool, so
+// routes sharing a pool hedge by their own speed. Each request earns
+// Budget/100 of a hedge, so at most Budget percent of requests are hedged
+// over time.
+type Hedge struct {
+	Delay      time.Duration
+	Percentile float64 // 0 uses Delay
+	Budget     float64 // Percent of requests
+
+	mu        sync.Mutex
+	tokens    float64
+	latencies latencyWindow
+}
+
+// maxHedgeTokens bounds how many hedges a quiet route can save up for a burst
+const maxHedgeTokens = 10
+
+// NewHedge builds a hedging policy
+func NewHedge(delay time.Duration, percentile, budget float64) *Hedge {
+	return &Hedge{Delay: delay, Percentile: percentile, Budget: budget}
+}
+
+// applies reports whether req may be hedged
+func (h *Hedge) applies(req *fasthttp.Request) bool {
+	return h != nil && (req.Header.IsGet() || req.Header.IsHead() || req.Header.IsOptions())
+}
+
+// delay returns how long to wait before hedging; false if the route has not
+// seen enough requests to know its percentile yet
+func (h *Hedge) delay() (time.Duration, bool) {
+	if h.Percentile > 0 {
+		return h.latencies.percentile(h.Percentile)
+	}
+	return h.Delay, true
+}
+
+// earn adds one request's share of the budget
+func (h *Hedge) earn() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokens += h.Budget / 100
+	if h.tokens > maxHedgeTokens {
+		h.tokens = maxHedgeTokens
+	}
+}
+
+// spend takes one hedge from the budget if there is one
+func (h *Hedge) spend() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	return true
+}
+
+// refund returns a hedge that could not be sent
+func (h *Hedge) refund() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokens++
+}
+
+// attempt is the outcome of one copy of a hedged request
+type attempt struct {
+	resp    *fasthttp.Response
+	err     error
+	backend *url.URL
+	client  *attemptClient // Returned to the pool by whoever receives the attempt
+}
+
+// roundTrip sends req to backend and, if it hasn't answered within the
+// delay, a copy to another backend of p. It returns the first successful
+// response, or the last error, and the backend that produced it; the
+// response must be released by the caller. The copy still running is
+// cancelled. Each copy calls its backend's release once it ends, release
+// being the primary's. proxyHeader is the pool's PROXY header for this
+// client, or nil.
+func (h *Hedge) roundTrip(route string, p *Pool, backend *url.URL, req *fasthttp.Request, target *rewrite.Result, clientIP string, proxyHeader []byte, release func()) (*fasthttp.Response, *url.URL, error) {
+	h.earn()
+	results := make(chan attempt, 2)
+	send := func(b *url.URL, r *fasthttp.Request, c *attemptClient, release func()) {
+		defer fasthttp.ReleaseRequest(r)
+		defer release()
+		if proxyHeader != nil {
+			r.SetConnectionClose() // Clients that announce a client are not reused
+		}
+
+		start := time.Now()
+		resp := fasthttp.AcquireResponse()
+		err := c.hc.DoTimeout(r, resp, p.Timeout)
+		if err == nil {
+			h.latencies.record(time.Since(start))
+		}
+		results <- attempt{resp: resp, err: err, backend: b, client: c}
+	}
+
+	// Both copies are owned by their goroutines, which may outlive this call
+	primary := fasthttp.AcquireRequest()
+	req.CopyTo(primary)
+	running := map[*attemptClient]bool{}
+	c := p.attemptClient(backend, proxyHeader)
+	running[c] = true
+	go send(backend, primary, c, release)
+
+	var hedgeTimer <-chan time.Time
+	if d, ok := h.delay(); ok {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		hedgeTimer = t.C
+	}
+
+	pending := 1
+	for {
+		select {
+		case a := <-results:
+			pending--
+			delete(running, a.client)
+			p.putAttemptClient(a.backend, a.client)
+			if a.err != nil && pending > 0 {
+				fasthttp.ReleaseResponse(a.resp)
+				continue
+			}
+			if a.err == nil && a.backend != backend {
+				metrics.HedgeRequests.WithLabelValues(route, "won").Inc()
+			}
+			// Cancel the copy still running and discard what it returns
+			for c := range running {
+				c.cancel()
+			}
+			go func(n int) {
+				for ; n > 0; n-- {
+					a := <-results
+					fasthttp.ReleaseResponse(a.resp)
+					p.putAttemptClient(a.backend, a.client)
+				}
+			}(pending)
+			return a.resp, a.backend, a.err
+
+		case <-hedgeTimer:
+			hedgeTimer = nil
+			// Checked first so requests over budget don't move the balancer on
+			if !h.spend() {
+				metrics.HedgeRequests.WithLabelValues(route, "over_budget").Inc()
+				continue
+			}
+			other := p.Balancer.GetNextBackend(clientIP)
+			if other == nil {
+				h.refund()
+				continue
+			}
+			release := func() {
+				if tracker, ok := p.Balancer.(algorithm.ConnectionTracker); ok {
+					tracker.Release(other)
+				}
+			}
+			if other.Host == backend.Host {
+				release()
+				h.refund()
+				continue
+			}
+			metrics.HedgeRequests.WithLabelValues(route, "sent").Inc()
+
+			hedged := fasthttp.AcquireRequest()
+			req.CopyTo(hedged)
+			hedged.SetRequestURI(other.String() + target.URI())
+			if target.Host == "" {
+				hedged.SetHost(other.Host)
+			}
+			pending++
+			c := p.attemptClient(other, proxyHeader)
+			running[c] = true
+			go send(other, hedged, c, release)
+		}
+	}
+}
+
+// attemptClient sends one copy of a hedged request over a connection of
+// its own, so that closing the connection cancels the copy
+type attemptClient struct {
+	hc *fasthttp.HostClient
+
+	mu        sync.Mutex
+	conn      net.Conn
+	cancelled bool
+}
+
+// attemptClient returns a client for backend, reusing an idle one unless
+// proxyHeader is set: those connections announce a single client
+func (p *Pool) attemptClient(backend *url.URL, proxyHeader []byte) *attemptClient {
+	if proxyHeader == nil {
+		if cp, ok := p.attemptClients.Load(backend.Host); ok {
+			if c, ok := cp.(*sync.Pool).Get().(*attemptClient); ok {
+				c.mu.Lock()
+				c.cancelled = false
+				c.mu.Unlock()
+				return c
+			}
+		}
+	}
+
+	isTLS := backend.Scheme == "https"
+	c := &attemptClient{}
+	timeout := p.Timeout
+	c.hc = &fasthttp.HostClient{
+		Addr:                   fasthttp.AddMissingPort(backend.Host, isTLS),
+		IsTLS:                  isTLS,
+		TLSConfig:              p.TLSConfig,
+		MaxConns:               1,
+		DisablePathNormalizing: true,
+		// Stale keep-alive connections are retried, but a cancelled copy must not be resent
+		RetryIfErr: func(*fasthttp.Request, int, error) (bool, bool) {
+			return false, !c.isCancelled()
+		},
+		Dial: func(addr string) (net.Conn, error) {
+			conn, err := fasthttp.DialTimeout(addr, timeout)
+			if err != nil {
+				return nil, err
+			}
+			if proxyHeader != nil {
+				if err := writeProxyHeader(conn, proxyHeader, timeout); err != nil {
+					conn.Close()
+					return nil, err
+				}
+			}
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.cancelled {
+				conn.Close()
+				return nil, errHedgeCancelled
+			}
+			c.conn = conn
+			return conn, nil
+		},
+	}
+	return c
+}
+
+// putAttemptClient keeps c for the next copy sent to backend
+func (p *Pool) putAttemptClient(backend *url.URL, c *attemptClient) {
+	if p.SendProxyProtocol != 0 {
+		return
+	}
+	cp, _ := p.attemptClients.LoadOrStore(backend.Host, &sync.Pool{})
+	cp.(*sync.Pool).Put(c)
+}
+
+func (c *attemptClient) isCancelled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cancelled
+}
+
+// cancel closes the connection of a copy that lost the race
+func (c *attemptClient) cancel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancelled = true
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+var errHedgeCancelled = errors.New("hedged request cancelled")
+
+// latencyWindow keeps the most recent request latencies of a route
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples [512]time.Duration
+	n       int // Samples recorded, up to len(samples)
+	next    int
+	fresh   int // Samples since the percentile was computed
+	cached  time.Duration
+}
+
+// minLatencySamples is how many requests a route needs before percentile delays apply
+const minLatencySamples = 100
+
+func (w *latencyWindow) record(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % len(w.samples)
+	if w.n < len(w.samples) {
+		w.n++
+	}
+	w.fresh++
+}
+
+// percentile returns the latency at p (0-100), recomputed every 64 samples;
+// a window serves a single percentile
+func (w *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.n < minLatencySamples {
+		return 0, false
+	}
+	if w.cached > 0 && w.fresh < 64 {
+		return w.cached, true
+	}
+	sorted := make([]time.Duration, w.n)
+	copy(sorted, w.samples[:w.n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(p / 100 * float64(w.n-1))
+	w.cached = sorted[i]
+	w.fresh = 0
+	return w.cached, true
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/rewrite"
+	"github.com/valyala/fasthttp"
+)
+
+// stallingBackend reads one request and never answers; closed receives a
+// value once the proxy closes the connection
+func stallingBackend(t *testing.T) (string, <-chan struct{}) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	closed := make(chan struct{}, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		var req fasthttp.Request
+		if req.Read(br) != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := br.ReadByte(); err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				closed <- struct{}{}
+			}
+		}
+	}()
+	return "http://" + ln.Addr().String(), closed
+}
+
+func TestHedgeCancelsTheLoser(t *testing.T) {
+	slow, closed := stallingBackend(t)
+	fast, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fast.Close()
+	go (&fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) { ctx.SetBodyString("fast") }}).Serve(fast)
+
+	// The balancer only knows the fast backend, so the hedge goes there
+	p := NewPool("hedge", []string{"http://" + fast.Addr().String()}, "round_robin", time.Hour, nil)
+	primary, _ := url.Parse(slow)
+	var primaryReleased atomic.Bool
+	h := NewHedge(20*time.Millisecond, 0, 100)
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(slow + "/x")
+	start := time.Now()
+	resp, winner, err := h.roundTrip("/", p, primary, req, &rewrite.Result{Path: "/x"}, "192.0.2.1", nil, func() { primaryReleased.Store(true) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fasthttp.ReleaseResponse(resp)
+	if string(resp.Body()) != "fast" || winner.Host != fast.Addr().String() {
+		t.Fatalf("winner %s answered %q", winner.Host, resp.Body())
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("hedged request took %v", d)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the losing request's connection was left open")
+	}
+	deadline := time.Now().Add(time.Second)
+	for !primaryReleased.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !primaryReleased.Load() {
+		t.Error("the losing backend was never released")
+	}
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/siddhu949/leanbalancer/internal/rewrite"
+	"github.com/siddhu949/leanbalancer/pkg/pool"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+)
+
+// Hop-by-hop headers that must not be forwarded over HTTP/2
+var hopHeaders = map[string]bool{
+	fasthttp.HeaderConnection:       true,
+	"Keep-Alive":                    true,
+	"Proxy-Connection":              true,
+	fasthttp.HeaderTransferEncoding: true,
+	fasthttp.HeaderUpgrade:          true,
+	fasthttp.HeaderHost:             true,
+}
+
+// SetProtocol selects how the pool talks to its backends:
+// "http1" (default), "h2" (HTTP/2 over TLS) or "h2c" (cleartext HTTP/2)
+func (p *Pool) SetProtocol(protocol string) error {
+	switch protocol {
+	case "", "http1", "http/1.1":
+		p.HTTP2 = nil
+	case "h2":
+		p.HTTP2 = &http2.Transport{TLSClientConfig: p.TLSConfig}
+	case "h2c":
+		p.HTTP2 = &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		}
+	default:
+		return fmt.Errorf("pool %s: unknown protocol %q", p.Name, protocol)
+	}
+	return nil
+}
+
+// roundTripHTTP2 forwards the request to an HTTP/2 backend and copies the
+// response into ctx. It returns the upstream status code. release is called
+// once the response is complete, after a streamed body has been sent.
+func roundTripHTTP2(ctx *fasthttp.RequestCtx, p *Pool, backend *url.URL, target *rewrite.Result, release func()) (int, error) {
+
+	var body io.Reader = http.NoBody
+	contentLength := int64(ctx.Request.Header.ContentLength())
+	if ctx.Request.IsBodyStream() {
+		body = ctx.RequestBodyStream()
+	} else if b := ctx.Request.Body(); len(b) > 0 {
+		body = bytes.NewReader(b)
+		contentLength = int64(len(b))
+	}
+	if contentLength < 0 {
+		contentLength = -1
+	}
+
+	streaming := pool.StreamingEnabled()
+	reqCtx, cancel := context.Background(), context.CancelFunc(func() {})
+	if !streaming {
+		reqCtx, cancel = context.WithTimeout(reqCtx, p.Timeout)
+	}
+
+	req, err := http.NewRequestWithContext(reqCtx, string(ctx.Method()), backend.String()+target.URI(), body)
+	if err != nil {
+		cancel()
+		release()
+		return 0, err
+	}
+	req.ContentLength = contentLength
+	req.Host = backend.Host
+	if target.Host != "" {
+		req.Host = target.Host
+	}
+	ctx.Request.Header.VisitAll(func(k, v []byte) {
+		if !hopHeaders[string(k)] {
+			req.Header.Add(string(k), string(v))
+		}
+	})
+
+	resp, err := p.HTTP2.RoundTrip(req)
+	if err != nil {
+		cancel()
+		release()
+		return 0, err
+	}
+
+	ctx.SetStatusCode(resp.StatusCode)
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			ctx.Response.Header.Add(k, v)
+		}
+	}
+
+	if streaming {
+		// fasthttp closes the body (and with it the stream) once it is sent
+		ctx.Response.SetBodyStream(&cancelOnClose{ReadCloser: resp.Body, cancel: func() {
+			cancel()
+			release()
+		}}, int(resp.ContentLength))
+		return resp.StatusCode, nil
+	}
+
+	defer release()
+	defer cancel()
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	ctx.Response.SetBody(b)
+	return resp.StatusCode, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
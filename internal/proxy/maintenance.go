@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/errorpages"
+	"github.com/valyala/fasthttp"
+)
+
+// maintenance takes a route out of service with 503 and Retry-After
+type maintenance struct {
+	mu         sync.RWMutex
+	enabled    bool
+	retryAfter time.Duration
+}
+
+// SetMaintenance turns maintenance mode on or off; retryAfter is sent to
+// clients, rounded to seconds, and 0 leaves the header out
+func (r *Route) SetMaintenance(enabled bool, retryAfter time.Duration) {
+	r.maintenance.mu.Lock()
+	defer r.maintenance.mu.Unlock()
+	r.maintenance.enabled = enabled
+	r.maintenance.retryAfter = retryAfter
+}
+
+// Maintenance reports whether the route is in maintenance mode and the Retry-After it sends
+func (r *Route) Maintenance() (bool, time.Duration) {
+	r.maintenance.mu.RLock()
+	defer r.maintenance.mu.RUnlock()
+	return r.maintenance.enabled, r.maintenance.retryAfter
+}
+
+// serveMaintenance answers with 503 if the route is in maintenance mode
+func (r *Route) serveMaintenance(ctx *fasthttp.RequestCtx) bool {
+	enabled, retryAfter := r.Maintenance()
+	if !enabled {
+		return false
+	}
+	errorpages.Error(ctx, "Service under maintenance", fasthttp.StatusServiceUnavailable)
+	// Set after Error, which resets the response
+	if retryAfter > 0 {
+		ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(int(retryAfter.Seconds())))
+	}
+	return true
+}
+
+// Routes returns the registered routes, longest prefix first
+func Routes() []*Route {
+	poolsMu.RLock()
+	defer poolsMu.RUnlock()
+	return append([]*Route(nil), routes...)
+}
+
+// GetRoute returns the route with exactly this path prefix, or nil
+func GetRoute(prefix string) *Route {
+	poolsMu.RLock()
+	defer poolsMu.RUnlock()
+	for _, r := range routes {
+		if r.PathPrefix == prefix {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"errors"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/headers"
+	"github.com/siddhu949/leanbalancer/internal/metrics"
+	"github.com/siddhu949/leanbalancer/internal/realip"
+	"github.com/siddhu949/leanbalancer/internal/rewrite"
+	"github.com/siddhu949/leanbalancer/pkg/algorithm"
+	"github.com/valyala/fasthttp"
+)
+
+var errNoBackends = errors.New("no available backends")
+
+// Mirror copies a share of a route's requests to a shadow pool and discards
+// the responses. Shadow requests run in their own goroutines with their own
+// timeout, so they never delay or fail the primary one; once MaxConcurrent
+// are in flight further copies are dropped.
+type Mirror struct {
+	Pool    *Pool
+	Percent float64 // 0 to 100
+	Timeout time.Duration
+
+	slots chan struct{}
+}
+
+// NewMirror builds a mirror to p
+func NewMirror(p *Pool, percent float64, maxConcurrent int, timeout time.Duration) *Mirror {
+	return &Mirror{
+		Pool:    p,
+		Percent: percent,
+		Timeout: timeout,
+		slots:   make(chan struct{}, maxConcurrent),
+	}
+}
+
+// shadow is a mirrored request waiting for the primary's result
+type shadow struct {
+	primary chan shadowResult
+	start   time.Time
+}
+
+type shadowResult struct {
+	status   int
+	duration time.Duration
+}
+
+// done reports the primary response so the shadow one can be compared with it
+func (s *shadow) done(status int) {
+	s.primary <- shadowResult{status: status, duration: time.Since(s.start)}
+}
+
+// start sends a copy of the request to the shadow pool if it is sampled and
+// a slot is free. The copy gets the route's request header rules, as the
+// primary does. The caller must call done on the result, if any.
+func (m *Mirror) start(ctx *fasthttp.RequestCtx, target *rewrite.Result, route *Route) *shadow {
+	if m.Percent < 100 && rand.Float64()*100 >= m.Percent {
+		return nil
+	}
+	// Streamed bodies can only be read once, by the primary request
+	if ctx.Request.IsBodyStream() || isUpgradeRequest(ctx) {
+		return nil
+	}
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		metrics.MirrorRequests.WithLabelValues(route.PathPrefix, "dropped").Inc()
+		return nil
+	}
+
+	req := fasthttp.AcquireRequest()
+	ctx.Request.Header.CopyTo(&req.Header)
+	req.SetBody(ctx.Request.Body())
+	clientIP := realip.ClientIP(ctx)
+	proxyHeader := m.Pool.proxyHeader(ctx)
+	// The shadow backend is only known once the copy is sent
+	var vars *headers.Vars
+	if route.RequestHeaders != nil {
+		vars = headerVars(ctx, route, "")
+		vars.Pool = m.Pool.Name
+	}
+	s := &shadow{primary: make(chan shadowResult, 1), start: time.Now()}
+
+	label := route.PathPrefix
+	go func() {
+		status, err := m.send(req, target, clientIP, proxyHeader, route.RequestHeaders, vars)
+		duration := time.Since(s.start)
+		fasthttp.ReleaseRequest(req)
+		// Free the slot now, not once the primary has answered
+		<-m.slots
+
+		primary := <-s.primary
+		metrics.MirrorResponses.WithLabelValues(label, "primary", strconv.Itoa(primary.status)).Inc()
+		metrics.MirrorDuration.WithLabelValues(label, "primary").Observe(primary.duration.Seconds())
+		if err != nil {
+			metrics.MirrorRequests.WithLabelValues(label, "error").Inc()
+			return
+		}
+		metrics.MirrorResponses.WithLabelValues(label, "shadow", strconv.Itoa(status)).Inc()
+		metrics.MirrorDuration.WithLabelValues(label, "shadow").Observe(duration.Seconds())
+		if status == primary.status {
+			metrics.MirrorRequests.WithLabelValues(label, "match").Inc()
+		} else {
+			metrics.MirrorRequests.WithLabelValues(label, "mismatch").Inc()
+		}
+	}()
+	return s
+}
+
+// send forwards req to a backend of the shadow pool and returns its status.
+// rules, if set, are applied once the backend is chosen.
+func (m *Mirror) send(req *fasthttp.Request, target *rewrite.Result, clientIP string, proxyHeader []byte, rules *headers.Rules, vars *headers.Vars) (int, error) {
+	backend := m.Pool.Balancer.GetNextBackend(clientIP)
+	if backend == nil {
+		return 0, errNoBackends
+	}
+	if tracker, ok := m.Pool.Balancer.(algorithm.ConnectionTracker); ok {
+		defer tracker.Release(backend)
+	}
+
+	req.SetRequestURI(backend.String() + target.URI())
+	if target.Host != "" {
+		req.UseHostHeader = true
+		req.Header.SetHost(target.Host)
+	} else {
+		req.SetHost(backend.Host)
+	}
+	if rules != nil {
+		vars.Backend = backend.Host
+		rules.ApplyRequest(&req.Header, vars)
+	}
+
+	client, done := m.Pool.client(proxyHeader)
+	defer done()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if err := client.DoTimeout(req, resp, m.Timeout); err != nil {
+		return 0, err
+	}
+	resp.CloseBodyStream()
+	return resp.StatusCode(), nil
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/headers"
+	"github.com/siddhu949/leanbalancer/internal/rewrite"
+	"github.com/valyala/fasthttp"
+)
+
+func TestMirrorAppliesRouteHeadersAndFreesItsSlot(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan [2]string, 1)
+	go (&fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		got <- [2]string{string(ctx.Request.Header.Peek("X-Secret")), string(ctx.Request.Header.Peek("X-Shadow-Pool"))}
+	}}).Serve(ln)
+
+	rules, err := headers.NewRules(nil, map[string]string{"X-Shadow-Pool": "${pool}"}, []string{"X-Secret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMirror(NewPool("shadow", []string{"http://" + ln.Addr().String()}, "round_robin", time.Hour, nil), 100, 1, time.Second)
+	route := &Route{PathPrefix: "/", RequestHeaders: rules, Mirror: m}
+
+	var ctx fasthttp.RequestCtx
+	ctx.Request.SetRequestURI("/x")
+	ctx.Request.Header.Set("X-Secret", "token")
+	s := m.start(&ctx, &rewrite.Result{Path: "/x"}, route)
+	if s == nil {
+		t.Fatal("request was not mirrored")
+	}
+	defer s.done(fasthttp.StatusOK)
+
+	select {
+	case h := <-got:
+		if h[0] != "" || h[1] != "shadow" {
+			t.Errorf("shadow got X-Secret %q and X-Shadow-Pool %q", h[0], h[1])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shadow request never arrived")
+	}
+
+	// The primary has not answered yet, but the shadow one has, so its slot
+	// must be free for the next copy
+	deadline := time.Now().Add(time.Second)
+	for len(m.slots) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(m.slots) != 0 {
+		t.Fatal("slot was held until the primary answered")
+	}
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/errorpages"
+	"github.com/siddhu949/leanbalancer/internal/headers"
+	"github.com/siddhu949/leanbalancer/internal/health"
+	"github.com/siddhu949/leanbalancer/internal/ratelimit"
+	"github.com/siddhu949/leanbalancer/internal/rewrite"
+	"github.com/siddhu949/leanbalancer/pkg/algorithm"
+	"github.com/siddhu949/leanbalancer/pkg/pool"
+	"golang.org/x/net/http2"
+)
+
+// DefaultPoolName is the pool built from health_check.backends and served on /reverse
+const DefaultPoolName = "default"
+
+// Pool is a named group of backends with its own balancer and HTTP clients
+type Pool struct {
+	Name          string
+	HealthChecker *health.HealthChecker
+	Balancer      algorithm.Balancer
+	Clients       *pool.ClientPool
+	TLSConfig     *tls.Config      // For https:// backends; nil uses system roots
+	HTTP2         *http2.Transport // Set for h2/h2c pools, nil for HTTP/1.1
+	Timeout       time.Duration    // Backend requests and upgrade dials
+
+	SendProxyProtocol int // proxyproto.V1 or V2 header on backend connections, 0 to disable
+
+	attemptClients    sync.Map // Backend host -> *sync.Pool of hedging clients
+	proxyClients      sync.Map // PROXY header -> *proxyClient
+	proxyClientsSwept atomic.Int64
+}
+
+// DefaultTimeout bounds backend requests of pools that do not set their own
+const DefaultTimeout = 3 * time.Second
+
+// NewPool builds a pool; tlsConfig applies to https:// backends and their health checks
+func NewPool(name string, backends []string, algorithmName string, interval time.Duration, tlsConfig *tls.Config) *Pool {
+	hc := health.NewHealthChecker(backends, interval)
+	hc.TLSConfig = tlsConfig
+	return &Pool{
+		Name:          name,
+		HealthChecker: hc,
+		Balancer:      algorithm.NewBalancer(algorithmName, hc),
+		Clients:       pool.NewClientPool(tlsConfig),
+		TLSConfig:     tlsConfig,
+		Timeout:       DefaultTimeout,
+	}
+}
+
+// Route types
+const (
+	RouteHTTP = "http"
+	RouteGRPC = "grpc" // Per-call balanced gRPC over HTTP/2
+)
+
+// Route sends requests whose path starts with PathPrefix to a pool, or
+// divides them between pools with a Split
+type Route struct {
+	PathPrefix  string
+	Type        string
+	Pool        *Pool          // Nil for split routes and routes that only redirect
+	Split       *Split         // Weighted pools instead of Pool
+	Mirror      *Mirror        // Copies a share of requests to a shadow pool
+	Hedge       *Hedge         // Retries slow idempotent requests on a second backend
+	MaxBodySize int            // Bytes; 0 uses the server's limit
+	Cache       bool           // Serve GET/HEAD through the response cache
+	Rewrite     *rewrite.Rules // Applied before the cache and the pool
+
+	RateLimit    *ratelimit.Limiter // Requests over the limit get 429
+	RateLimitKey ratelimit.KeyFunc  // What RateLimit counts by; nil is the client IP
+
+	RequestHeaders  *headers.Rules    // Applied before proxying, once the backend is chosen
+	ResponseHeaders *headers.Rules    // Applied before returning, including cached responses
+	ErrorPages      *errorpages.Pages // Replace the proxy's own error responses
+
+	maintenance maintenance
+}
+
+var (
+	poolsMu sync.RWMutex
+	pools   = map[string]*Pool{}
+	routes  []*Route // Sorted longest prefix first
+)
+
+// RegisterPool adds or replaces a pool by name
+func RegisterPool(p *Pool) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	pools[p.Name] = p
+}
+
+// GetPool returns the named pool, or nil
+func GetPool(name string) *Pool {
+	poolsMu.RLock()
+	defer poolsMu.RUnlock()
+	return pools[name]
+}
+
+// AddRoute registers a route; gRPC routes need h2 or h2c pools, and only
+// http routes with redirect rules may go without a pool
+func AddRoute(route *Route) error {
+	pools := []*Pool{route.Pool}
+	if route.Split != nil {
+		if route.Pool != nil {
+			return fmt.Errorf("route %s: set either a pool or a split", route.PathPrefix)
+		}
+		// One cached response would be served whichever pool a client is assigned
+		if route.Cache {
+			return fmt.Errorf("route %s: cache can't be combined with a split", route.PathPrefix)
+		}
+		pools = route.Split.Pools()
+	}
+
+	if route.Hedge != nil {
+		// A hedged copy can only win once its whole response is buffered
+		if pool.StreamingEnabled() {
+			return fmt.Errorf("route %s: hedging can't be combined with body streaming", route.PathPrefix)
+		}
+		for _, p := range pools {
+			if p != nil && p.HTTP2 != nil {
+				return fmt.Errorf("route %s: hedging needs HTTP/1.1 pools, not %q", route.PathPrefix, p.Name)
+			}
+		}
+	}
+	if route.Mirror != nil && route.Mirror.Pool.HTTP2 != nil {
+		return fmt.Errorf("route %s: mirror pool %q must speak HTTP/1.1", route.PathPrefix, route.Mirror.Pool.Name)
+	}
+
+	switch route.Type {
+	case "", RouteHTTP:
+		route.Type = RouteHTTP
+		if route.Pool == nil && route.Split == nil && !route.Rewrite.Redirects() {
+			return fmt.Errorf("route %s: no pool", route.PathPrefix)
+		}
+	case RouteGRPC:
+		if route.Pool == nil && route.Split == nil {
+			return fmt.Errorf("route %s: no pool", route.PathPrefix)
+		}
+		if route.Rewrite != nil || route.Mirror != nil {
+			return fmt.Errorf("route %s: gRPC routes can't rewrite or mirror", route.PathPrefix)
+		}
+		for _, p := range pools {
+			if p.HTTP2 == nil {
+				return fmt.Errorf("route %s: gRPC needs pool %q with protocol h2 or h2c", route.PathPrefix, p.Name)
+			}
+		}
+	default:
+		return fmt.Errorf("route %s: unknown type %q", route.PathPrefix, route.Type)
+	}
+
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	routes = append(routes, route)
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].PathPrefix) > len(routes[j].PathPrefix)
+	})
+	return nil
+}
+
+// MatchRoute returns the longest-prefix route for path, or nil
+func MatchRoute(path string) *Route {
+	poolsMu.RLock()
+	defer poolsMu.RUnlock()
+	for _, r := range routes {
+		if strings.HasPrefix(path, r.PathPrefix) {
+			return r
+		}
+	}
+	return nil
+}
@@ -1,68 +1,249 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"net/url"
 	"time"
 
-	"github.com/siddhu949/leanbalancer/internal/health"
+	"github.com/siddhu949/leanbalancer/internal/errorpages"
+	"github.com/siddhu949/leanbalancer/internal/headers"
+	"github.com/siddhu949/leanbalancer/internal/metrics"
+	"github.com/siddhu949/leanbalancer/internal/ratelimit"
+	"github.com/siddhu949/leanbalancer/internal/realip"
+	"github.com/siddhu949/leanbalancer/internal/rewrite"
 	"github.com/siddhu949/leanbalancer/pkg/algorithm"
 	"github.com/siddhu949/leanbalancer/pkg/pool"
 	"github.com/siddhu949/leanbalancer/pkg/utils"
 	"github.com/valyala/fasthttp"
 )
 
-// Health Checker initialized with backends
-var healthChecker = health.NewHealthChecker([]string{
+// Default pool until one is registered from config
+var defaultPool = NewPool(DefaultPoolName, []string{
 	"http://localhost:9001",
 	"http://localhost:9002",
 	"http://localhost:9003",
-}, 5*time.Second)
+}, "round_robin", 5*time.Second, nil)
 
-// Round Robin instance
-var roundRobin = algorithm.NewRoundRobin(healthChecker)
+// SetDefaultPool sets the pool served on /reverse
+func SetDefaultPool(p *Pool) {
+	defaultPool = p
+	RegisterPool(p)
+}
+
+// reverseRewrite strips /reverse before requests reach the default pool
+var reverseRewrite, _ = rewrite.New([]rewrite.Rule{{Match: "^/reverse(/.*)?$", Path: "${1}"}})
 
 // ReverseProxyHandler handles reverse proxy requests
 func ReverseProxyHandler(ctx *fasthttp.RequestCtx) {
+	proxyToPool(ctx, defaultPool, reverseRewrite.Apply(ctx.URI()), nil)
+}
+
+// RouteHandler proxies a request matched by a configured route
+func RouteHandler(ctx *fasthttp.RequestCtx, route *Route) {
+	if route.serveMaintenance(ctx) {
+		return
+	}
+	if route.RateLimit != nil {
+		key := route.RateLimitKey
+		if key == nil {
+			key = ratelimit.ClientIPKey
+		}
+		result := route.RateLimit.Allow(key(ctx))
+		if !result.Allowed {
+			metrics.RateLimited.WithLabelValues(route.PathPrefix).Inc()
+			errorpages.Error(ctx, "Too many requests", fasthttp.StatusTooManyRequests)
+			// Set after Error, which resets the response
+			route.RateLimit.SetHeaders(&ctx.Response.Header, result)
+			return
+		}
+		// Deferred, as proxying replaces the response headers
+		defer route.RateLimit.SetHeaders(&ctx.Response.Header, result)
+	}
+	if route.Type == RouteGRPC {
+		grpcHandler(ctx, route.pick(ctx))
+		return
+	}
+	// HTTP/1.1 bodies are limited while they are read; this catches HTTP/2
+	if route.MaxBodySize > 0 && ctx.Request.Header.ContentLength() > route.MaxBodySize {
+		errorpages.Error(ctx, "Request body too large", fasthttp.StatusRequestEntityTooLarge)
+		return
+	}
+	if route.ResponseHeaders != nil {
+		// Fix the request ID before the request is copied for cache fetches
+		headers.RequestID(ctx)
+		defer func() {
+			backend, _ := ctx.UserValue(backendUserValue).(string)
+			route.ResponseHeaders.ApplyResponse(&ctx.Response.Header, headerVars(ctx, route, backend))
+		}()
+	}
+
+	target := route.Rewrite.Apply(ctx.URI())
+	if target.Redirect != 0 {
+		ctx.Response.Header.Set(fasthttp.HeaderLocation, target.Location)
+		ctx.SetStatusCode(target.Redirect)
+		return
+	}
+	// Redirect-only routes have no pool for the requests they don't redirect
+	p := route.pick(ctx)
+	if p == nil {
+		errorpages.Error(ctx, "404 - Not Found", fasthttp.StatusNotFound)
+		return
+	}
+	if route.Mirror != nil {
+		if s := route.Mirror.start(ctx, target, route); s != nil {
+			defer func() { s.done(ctx.Response.StatusCode()) }()
+		}
+	}
+
+	if route.Cache && responseCache != nil {
+		cachedProxy(ctx, route, target)
+		return
+	}
+	proxyToPool(ctx, p, target, route)
+}
+
+// backendUserValue and poolUserValue record where a request was sent
+const (
+	backendUserValue = "proxy.backend"
+	poolUserValue    = "proxy.pool"
+)
+
+// headerVars collects the values for header templates
+func headerVars(ctx *fasthttp.RequestCtx, route *Route, backend string) *headers.Vars {
+	poolName, _ := ctx.UserValue(poolUserValue).(string)
+	if poolName == "" && route.Pool != nil {
+		poolName = route.Pool.Name
+	}
+	return &headers.Vars{
+		ClientIP:  realip.ClientIP(ctx),
+		RequestID: headers.RequestID(ctx),
+		Backend:   backend,
+		Route:     route.PathPrefix,
+		Pool:      poolName,
+		Host:      string(ctx.Host()),
+		Method:    string(ctx.Method()),
+		Path:      string(ctx.Path()),
+		Time:      time.Now(),
+	}
+}
+
+// forwardErrorStatus is 504 when the backend timed out, 413 when the
+// request body outgrew its limit and 503 otherwise
+func forwardErrorStatus(err error) int {
+	if errors.Is(err, fasthttp.ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return fasthttp.StatusGatewayTimeout
+	}
+	// A streamed HTTP/2 body that went over the limit on the way upstream
+	if errors.Is(err, fasthttp.ErrBodyTooLarge) {
+		return fasthttp.StatusRequestEntityTooLarge
+	}
+	return fasthttp.StatusServiceUnavailable
+}
+
+// proxyToPool sends the request to a backend of p at the rewritten target;
+// route is nil for /reverse
+func proxyToPool(ctx *fasthttp.RequestCtx, p *Pool, target *rewrite.Result, route *Route) {
 	start := time.Now()
-	backend := roundRobin.GetNextBackend()
+	clientIP := realip.ClientIP(ctx)
+	backend := p.Balancer.GetNextBackend(clientIP)
 
 	if backend == nil {
-		ctx.Error("No available backends", fasthttp.StatusServiceUnavailable)
+		errorpages.Error(ctx, "No available backends", fasthttp.StatusServiceUnavailable)
 		return
 	}
+	release := func() {
+		if tracker, ok := p.Balancer.(algorithm.ConnectionTracker); ok {
+			tracker.Release(backend)
+		}
+	}
+	ctx.SetUserValue(backendUserValue, backend.Host)
+	ctx.SetUserValue(poolUserValue, p.Name)
+	if route != nil && route.RequestHeaders != nil {
+		route.RequestHeaders.ApplyRequest(&ctx.Request.Header, headerVars(ctx, route, backend.Host))
+	}
 
-	client := pool.GetClient()
-	defer pool.ReleaseClient(client)
+	// WebSocket and other upgrades become long-lived tunnels
+	if isUpgradeRequest(ctx) {
+		proxyUpgrade(ctx, p, backend, target, release)
+		return
+	}
+
+	// Pools that speak HTTP/2 upstream go through the HTTP/2 transport
+	if p.HTTP2 != nil {
+		status, err := roundTripHTTP2(ctx, p, backend, target, release)
+		if err != nil {
+			errorpages.Error(ctx, fmt.Sprintf("Error forwarding request: %s", err), forwardErrorStatus(err))
+			return
+		}
+		utils.LogRequest(clientIP, string(ctx.Method()), string(ctx.Path()), status, time.Since(start))
+		return
+	}
+
+	// Streamed bodies keep the backend busy until they are sent, and hedged
+	// copies release their backends themselves
+	handedOff := false
+	defer func() {
+		if !handedOff {
+			release()
+		}
+	}()
+
+	proxyHeader := p.proxyHeader(ctx)
+	client, done := p.client(proxyHeader)
+	defer done()
 
+	streaming := pool.StreamingEnabled()
 	req := fasthttp.AcquireRequest()
-	resp := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(resp)
 
 	// Copy incoming request
-	ctx.Request.CopyTo(req)
-
-	// Clean path (remove /reverse)
-	cleanPath := strings.TrimPrefix(string(ctx.Path()), "/reverse")
+	prepareRequest(ctx, req, streaming)
 
 	// Rebuild the new URI
-	req.SetRequestURI(backend.String() + cleanPath)
+	req.SetRequestURI(backend.String() + target.URI())
 
-	// Set the correct Host header for the backend
-	req.SetHost(backend.Host)
+	// Set the correct Host header for the backend, unless a rewrite chose one
+	if target.Host != "" {
+		req.UseHostHeader = true
+		req.Header.SetHost(target.Host)
+	} else {
+		req.SetHost(backend.Host)
+	}
 
 	// Optional: copy headers (already done via CopyTo, but you can double-check)
 	// ctx.Request.Header.CopyTo(&req.Header)
 
-	// Perform request with timeout
-	err := client.DoTimeout(req, resp, 3*time.Second)
+	// Perform request with timeout; streamed bodies are bounded by the idle timeout instead
+	var resp *fasthttp.Response
+	var err error
+	switch {
+	case streaming:
+		resp = fasthttp.AcquireResponse()
+		err = client.Do(req, resp)
+	case route != nil && route.Hedge.applies(req):
+		var winner *url.URL
+		handedOff = true
+		resp, winner, err = route.Hedge.roundTrip(route.PathPrefix, p, backend, req, target, clientIP, proxyHeader, release)
+		ctx.SetUserValue(backendUserValue, winner.Host)
+	default:
+		resp = fasthttp.AcquireResponse()
+		err = client.DoTimeout(req, resp, p.Timeout)
+	}
 	if err != nil {
-		ctx.Error(fmt.Sprintf("Error forwarding request: %s", err), fasthttp.StatusServiceUnavailable)
+		fasthttp.ReleaseResponse(resp)
+		errorpages.Error(ctx, fmt.Sprintf("Error forwarding request: %s", err), forwardErrorStatus(err))
 		return
 	}
 
 	// Log and send response
-	utils.LogRequest(string(ctx.Method()), string(ctx.Path()), resp.StatusCode(), time.Since(start))
+	utils.LogRequest(clientIP, string(ctx.Method()), string(ctx.Path()), resp.StatusCode(), time.Since(start))
+	if streaming {
+		handedOff = true
+		streamResponse(ctx, resp, func(int64) { release() })
+		return
+	}
 	resp.CopyTo(&ctx.Response)
+	fasthttp.ReleaseResponse(resp)
 }
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/proxyproto"
+	"github.com/siddhu949/leanbalancer/pkg/pool"
+	"github.com/valyala/fasthttp"
+)
+
+// SetSendProxyProtocol makes the pool open backend connections with a PROXY
+// header of the given version, 0 disabling it. Health checks send a LOCAL
+// header. HTTP/2 connections carry many clients, so h2 pools can't send one.
+func (p *Pool) SetSendProxyProtocol(version int) error {
+	if version != 0 && p.HTTP2 != nil {
+		return fmt.Errorf("pool %s: send_proxy_protocol needs an HTTP/1.1 or TCP pool", p.Name)
+	}
+	p.SendProxyProtocol = version
+	p.HealthChecker.ProxyHeader = nil
+	if version != 0 {
+		var local bytes.Buffer
+		if err := proxyproto.WriteHeader(&local, version, nil, nil); err != nil {
+			return fmt.Errorf("pool %s: %w", p.Name, err)
+		}
+		p.HealthChecker.ProxyHeader = local.Bytes()
+	}
+	return nil
+}
+
+// proxyHeader encodes the PROXY header announcing ctx's client to the
+// pool's backends, or returns nil if the pool doesn't send one
+func (p *Pool) proxyHeader(ctx *fasthttp.RequestCtx) []byte {
+	if p.SendProxyProtocol == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	proxyproto.WriteHeader(&buf, p.SendProxyProtocol, ctx.RemoteAddr(), ctx.LocalAddr())
+	return buf.Bytes()
+}
+
+// proxyClientIdle is how long a client kept for a PROXY header may go
+// unused before it is dropped with its connections
+const proxyClientIdle = 30 * time.Second
+
+// proxyClient is the client for one downstream connection's PROXY header
+type proxyClient struct {
+	client *fasthttp.Client
+	users  atomic.Int32
+	used   atomic.Int64 // Unix nanoseconds
+}
+
+// client returns an HTTP client for a request and a func to call when done
+// with it. Requests with a PROXY header can't share pooled connections: the
+// header names the downstream connection, so each one gets a client whose
+// connections start with it, and its requests reuse those connections.
+func (p *Pool) client(header []byte) (*fasthttp.Client, func()) {
+	if header == nil {
+		c := p.Clients.Get()
+		return c, func() { p.Clients.Release(c) }
+	}
+	p.sweepProxyClients(time.Now())
+	v, ok := p.proxyClients.Load(string(header))
+	if !ok {
+		v, _ = p.proxyClients.LoadOrStore(string(header), &proxyClient{client: p.newProxyClient(header)})
+	}
+	pc := v.(*proxyClient)
+	pc.users.Add(1)
+	return pc.client, func() {
+		pc.used.Store(time.Now().UnixNano())
+		pc.users.Add(-1)
+	}
+}
+
+// sweepProxyClients drops, at most once per proxyClientIdle, the clients
+// of downstream connections that have gone quiet
+func (p *Pool) sweepProxyClients(now time.Time) {
+	last := p.proxyClientsSwept.Load()
+	if now.UnixNano()-last < int64(proxyClientIdle) || !p.proxyClientsSwept.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	p.proxyClients.Range(func(key, v any) bool {
+		pc := v.(*proxyClient)
+		if pc.users.Load() == 0 && now.UnixNano()-pc.used.Load() > int64(proxyClientIdle) {
+			p.proxyClients.Delete(key)
+			pc.client.CloseIdleConnections()
+		}
+		return true
+	})
+}
+
+// newProxyClient builds a client whose connections start with header
+func (p *Pool) newProxyClient(header []byte) *fasthttp.Client {
+	timeout := p.Timeout
+	c := pool.NewClient(p.TLSConfig, func(addr string) (net.Conn, error) {
+		conn, err := fasthttp.DialTimeout(addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeProxyHeader(conn, header, timeout); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	})
+	return c
+}
+
+// writeProxyHeader sends header on a freshly dialed backend connection
+func writeProxyHeader(conn net.Conn, header []byte, timeout time.Duration) error {
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(header); err != nil {
+		return err
+	}
+	return conn.SetWriteDeadline(time.Time{})
+}
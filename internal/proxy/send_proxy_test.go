@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/proxyproto"
+	"github.com/valyala/fasthttp"
+)
+
+// proxyProtocolBackend starts an HTTP server that requires a PROXY header
+// and answers with the client address it announced
+func proxyProtocolBackend(t *testing.T) string {
+	backend, _ := countingProxyProtocolBackend(t)
+	return backend
+}
+
+// countingProxyProtocolBackend is proxyProtocolBackend, also counting the
+// connections it accepts
+func countingProxyProtocolBackend(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := proxyproto.NewListener(ln, nil, true)
+	t.Cleanup(func() { pl.Close() })
+	var conns atomic.Int32
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.SetBodyString(ctx.RemoteAddr().String())
+		},
+		ConnState: func(_ net.Conn, state fasthttp.ConnState) {
+			if state == fasthttp.StateNew {
+				conns.Add(1)
+			}
+		},
+	}
+	go s.Serve(pl)
+	return "http://" + ln.Addr().String(), &conns
+}
+
+// clientCtx is a request context for a client at addr
+func clientCtx(addr string) *fasthttp.RequestCtx {
+	tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&fasthttp.Request{}, tcpAddr, nil)
+	return ctx
+}
+
+func TestSendProxyProtocolHTTP(t *testing.T) {
+	backend := proxyProtocolBackend(t)
+	p := NewPool("pp", []string{backend}, "round_robin", time.Hour, nil)
+	if err := p.SetSendProxyProtocol(proxyproto.V2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each client must be announced on a connection of its own
+	for _, addr := range []string{"203.0.113.7:4000", "198.51.100.9:5000", "203.0.113.7:4001"} {
+		header := p.proxyHeader(clientCtx(addr))
+		client, done := p.client(header)
+		req := fasthttp.AcquireRequest()
+		resp := fasthttp.AcquireResponse()
+		req.SetRequestURI(backend + "/")
+		if err := client.DoTimeout(req, resp, time.Second); err != nil {
+			t.Fatalf("%s: %v", addr, err)
+		}
+		if got := string(resp.Body()); got != addr {
+			t.Errorf("backend saw %q, want %q", got, addr)
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+		done()
+	}
+}
+
+func TestSendProxyProtocolReusesConnectionsPerClient(t *testing.T) {
+	backend, conns := countingProxyProtocolBackend(t)
+	p := NewPool("pp", []string{backend}, "round_robin", time.Hour, nil)
+	if err := p.SetSendProxyProtocol(proxyproto.V1); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(addr string) {
+		t.Helper()
+		client, done := p.client(p.proxyHeader(clientCtx(addr)))
+		defer done()
+		var req fasthttp.Request
+		var resp fasthttp.Response
+		req.SetRequestURI(backend + "/")
+		if err := client.DoTimeout(&req, &resp, time.Second); err != nil {
+			t.Fatalf("%s: %v", addr, err)
+		}
+		if got := string(resp.Body()); got != addr {
+			t.Errorf("backend saw %q, want %q", got, addr)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		send("203.0.113.7:4000")
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("one client connection opened %d backend connections", n)
+	}
+	send("198.51.100.9:5000")
+	if n := conns.Load(); n != 2 {
+		t.Errorf("two client connections opened %d backend connections", n)
+	}
+
+	// Once idle, both clients are dropped
+	p.sweepProxyClients(time.Now().Add(2 * proxyClientIdle))
+	remaining := 0
+	p.proxyClients.Range(func(_, _ any) bool {
+		remaining++
+		return true
+	})
+	if remaining != 0 {
+		t.Errorf("%d idle clients were kept", remaining)
+	}
+}
+
+func TestSendProxyProtocolUpgradeDial(t *testing.T) {
+	backend := proxyProtocolBackend(t)
+	p := NewPool("pp", []string{backend}, "round_robin", time.Hour, nil)
+	if err := p.SetSendProxyProtocol(proxyproto.V1); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(backend)
+	conn, err := dialBackend(u, nil, time.Second, p.proxyHeader(clientCtx("192.0.2.1:6000")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"))
+	var resp fasthttp.Response
+	if err := resp.Read(bufio.NewReader(conn)); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(resp.Body()); got != "192.0.2.1:6000" {
+		t.Errorf("backend saw %q, want 192.0.2.1:6000", got)
+	}
+}
+
+func TestSendProxyProtocolRejectsHTTP2(t *testing.T) {
+	p := NewPool("h2", []string{"http://127.0.0.1:1"}, "round_robin", time.Hour, nil)
+	if err := p.SetProtocol("h2c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetSendProxyProtocol(proxyproto.V2); err == nil || !strings.Contains(err.Error(), "send_proxy_protocol") {
+		t.Errorf("got %v, want an error for an h2c pool", err)
+	}
+}
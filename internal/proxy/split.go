@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"sync"
+
+	"github.com/siddhu949/leanbalancer/internal/metrics"
+	"github.com/siddhu949/leanbalancer/internal/realip"
+	"github.com/valyala/fasthttp"
+)
+
+// SplitTarget is one pool of a traffic split and its share
+type SplitTarget struct {
+	Pool   *Pool
+	Weight int
+}
+
+// Split divides a route's traffic between pools by weight. A request whose
+// Header or Cookie names one of the pools always goes there; with Sticky, a
+// hash of the client IP picks the pool instead of a random draw, so each
+// client keeps its pool while the weights stay the same.
+type Split struct {
+	Header string
+	Cookie string
+	Sticky bool
+
+	mu      sync.RWMutex
+	targets []SplitTarget
+}
+
+// NewSplit checks that the pools are distinct and the weights add up to more than zero
+func NewSplit(targets []SplitTarget, header, cookie string, sticky bool) (*Split, error) {
+	seen := map[string]bool{}
+	total := 0
+	for _, t := range targets {
+		if t.Pool == nil {
+			return nil, fmt.Errorf("split: no pool")
+		}
+		if seen[t.Pool.Name] {
+			return nil, fmt.Errorf("split: pool %q listed twice", t.Pool.Name)
+		}
+		if t.Weight < 0 {
+			return nil, fmt.Errorf("split: pool %q has a negative weight", t.Pool.Name)
+		}
+		seen[t.Pool.Name] = true
+		total += t.Weight
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("split: weights must add up to more than 0")
+	}
+	return &Split{Header: header, Cookie: cookie, Sticky: sticky, targets: targets}, nil
+}
+
+// Weights returns each pool's current weight
+func (s *Split) Weights() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	weights := make(map[string]int, len(s.targets))
+	for _, t := range s.targets {
+		weights[t.Pool.Name] = t.Weight
+	}
+	return weights
+}
+
+// SetWeights changes the weights of the named pools; pools left out keep theirs
+func (s *Split) SetWeights(weights map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	targets := append([]SplitTarget(nil), s.targets...)
+	for name, weight := range weights {
+		if weight < 0 {
+			return fmt.Errorf("pool %q has a negative weight", name)
+		}
+		found := false
+		for i := range targets {
+			if targets[i].Pool.Name == name {
+				targets[i].Weight = weight
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("pool %q is not part of the split", name)
+		}
+	}
+	total := 0
+	for _, t := range targets {
+		total += t.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("weights must add up to more than 0")
+	}
+	s.targets = targets
+	return nil
+}
+
+// Pools returns every pool of the split
+func (s *Split) Pools() []*Pool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pools := make([]*Pool, 0, len(s.targets))
+	for _, t := range s.targets {
+		pools = append(pools, t.Pool)
+	}
+	return pools
+}
+
+// pick returns the route's pool, or the split's choice for this request
+func (r *Route) pick(ctx *fasthttp.RequestCtx) *Pool {
+	if r.Split != nil {
+		return r.Split.choose(ctx, r.PathPrefix)
+	}
+	return r.Pool
+}
+
+// choose picks the pool for a request: a header or cookie override first,
+// then the client's hash bucket or a weighted random draw
+func (s *Split) choose(ctx *fasthttp.RequestCtx, route string) *Pool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p := s.override(ctx)
+	if p == nil {
+		total := 0
+		for _, t := range s.targets {
+			total += t.Weight
+		}
+		var n int
+		if s.Sticky {
+			h := fnv.New32a()
+			h.Write([]byte(realip.ClientIP(ctx)))
+			n = int(h.Sum32() % uint32(total))
+		} else {
+			n = rand.IntN(total)
+		}
+		for _, t := range s.targets {
+			if n < t.Weight {
+				p = t.Pool
+				break
+			}
+			n -= t.Weight
+		}
+	}
+	metrics.SplitRequests.WithLabelValues(route, p.Name).Inc()
+	return p
+}
+
+// override returns the pool named by the request's header or cookie, if any
+func (s *Split) override(ctx *fasthttp.RequestCtx) *Pool {
+	var name []byte
+	if s.Header != "" {
+		name = ctx.Request.Header.Peek(s.Header)
+	}
+	if len(name) == 0 && s.Cookie != "" {
+		name = ctx.Request.Header.Cookie(s.Cookie)
+	}
+	if len(name) == 0 {
+		return nil
+	}
+	for _, t := range s.targets {
+		if t.Pool.Name == string(name) {
+			return t.Pool
+		}
+	}
+	return nil
+}
+
+// RouteSplits returns the traffic splits by route path prefix
+func RouteSplits() map[string]*Split {
+	poolsMu.RLock()
+	defer poolsMu.RUnlock()
+	splits := map[string]*Split{}
+	for _, r := range routes {
+		if r.Split != nil {
+			splits[r.PathPrefix] = r.Split
+		}
+	}
+	return splits
+}
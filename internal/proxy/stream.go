@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+const streamBufferSize = 32 * 1024
+
+// prepareRequest copies the client request headers and body into req. When
+// streaming, the body is read from the client as it is sent upstream.
+// Only the header is copied so the backend scheme is not inherited from a TLS client.
+// The streamed body, if any, is returned so the bytes sent can be counted.
+func prepareRequest(ctx *fasthttp.RequestCtx, req *fasthttp.Request, streaming bool) *countingReader {
+	ctx.Request.Header.CopyTo(&req.Header)
+	if !streaming {
+		// Body() also drains bodies that arrived as a stream, e.g. over HTTP/2
+		req.SetBody(ctx.Request.Body())
+		return nil
+	}
+	// -1 is chunked; other negative values mean there is no body
+	if cl := ctx.Request.Header.ContentLength(); cl > 0 || cl == -1 {
+		body := &countingReader{Reader: ctx.RequestBodyStream()}
+		req.SetBodyStream(body, cl)
+		return body
+	}
+	return nil
+}
+
+// countingReader counts the bytes read through it, for bodies whose length
+// is not known up front
+type countingReader struct {
+	io.Reader
+	n atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// Count is the number of bytes read so far; zero on a nil reader
+func (r *countingReader) Count() int64 {
+	if r == nil {
+		return 0
+	}
+	return r.n.Load()
+}
+
+// streamResponse hands the upstream response to the client without buffering
+// the body. It takes ownership of resp and releases it once the body is sent,
+// then calls done, if it is not nil, with the number of body bytes sent.
+func streamResponse(ctx *fasthttp.RequestCtx, resp *fasthttp.Response, done func(sent int64)) {
+	resp.Header.CopyTo(&ctx.Response.Header)
+
+	stream := resp.BodyStream()
+	if stream == nil {
+		// Small bodies may already be fully read
+		ctx.Response.SetBody(resp.Body())
+		sent := int64(len(resp.Body()))
+		releaseStreamed(resp, func() {
+			if done != nil {
+				done(sent)
+			}
+		})
+		return
+	}
+	body := &countingReader{Reader: stream}
+	finished := func() {
+		if done != nil {
+			done(body.Count())
+		}
+	}
+
+	if cl := resp.Header.ContentLength(); cl >= 0 {
+		ctx.Response.SetBodyStream(&responseBody{resp: resp, body: body, done: finished}, cl)
+		return
+	}
+
+	// Unknown length (chunked, server-sent events): flush every read so
+	// events reach the client as soon as the backend emits them
+	ctx.Response.SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer releaseStreamed(resp, finished)
+		buf := make([]byte, streamBufferSize)
+		for {
+			n, err := body.Read(buf)
+			if n > 0 {
+				if _, werr := w.Write(buf[:n]); werr != nil {
+					return
+				}
+				if werr := w.Flush(); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
+
+// responseBody releases the upstream response when fasthttp closes the stream
+type responseBody struct {
+	resp *fasthttp.Response
+	body io.Reader
+	done func()
+}
+
+func (r *responseBody) Read(p []byte) (int, error) {
+	return r.body.Read(p)
+}
+
+func (r *responseBody) Close() error {
+	releaseStreamed(r.resp, r.done)
+	return nil
+}
+
+func releaseStreamed(resp *fasthttp.Response, done func()) {
+	resp.CloseBodyStream()
+	fasthttp.ReleaseResponse(resp)
+	if done != nil {
+		done()
+	}
+}
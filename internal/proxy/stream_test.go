@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/pkg/pool"
+	"github.com/valyala/fasthttp"
+)
+
+func TestStreamResponseReleasesAfterBody(t *testing.T) {
+	for _, cl := range []int{len("streamed body"), -1} {
+		resp := fasthttp.AcquireResponse()
+		body, upstream := io.Pipe()
+		resp.SetBodyStream(body, cl)
+		released := make(chan struct{})
+		var ctx fasthttp.RequestCtx
+		var sent int64
+		streamResponse(&ctx, resp, func(n int64) {
+			sent = n
+			close(released)
+		})
+
+		select {
+		case <-released:
+			t.Fatalf("content length %d: released before the body was sent", cl)
+		case <-time.After(50 * time.Millisecond):
+		}
+		go func() {
+			io.WriteString(upstream, "streamed body")
+			upstream.Close()
+		}()
+		if got := string(ctx.Response.Body()); got != "streamed body" {
+			t.Errorf("content length %d: body %q", cl, got)
+		}
+		select {
+		case <-released:
+		case <-time.After(time.Second):
+			t.Fatalf("content length %d: not released once the body was sent", cl)
+		}
+		if sent != int64(len("streamed body")) {
+			t.Errorf("content length %d: counted %d bytes sent", cl, sent)
+		}
+	}
+}
+
+func TestAddRouteRejectsHedgeWithStreaming(t *testing.T) {
+	pool.EnableStreaming(time.Second)
+	t.Cleanup(func() { pool.EnableStreaming(0) })
+
+	p := NewPool("hedged", []string{"http://127.0.0.1:1"}, "round_robin", time.Hour, nil)
+	err := AddRoute(&Route{PathPrefix: "/hedged", Pool: p, Hedge: NewHedge(10*time.Millisecond, 0, 10)})
+	if err == nil || !strings.Contains(err.Error(), "streaming") {
+		t.Errorf("got %v, want hedging to be rejected with streaming", err)
+	}
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/firewall"
+	"github.com/siddhu949/leanbalancer/internal/proxyproto"
+	"github.com/siddhu949/leanbalancer/pkg/algorithm"
+	"github.com/siddhu949/leanbalancer/pkg/utils"
+	"github.com/valyala/fasthttp"
+)
+
+// Backends tried per client connection before giving up
+const tcpConnectAttempts = 3
+
+// TCPProxy balances raw TCP connections across a pool (layer 4)
+type TCPProxy struct {
+	Name              string
+	Pool              *Pool
+	ConnectTimeout    time.Duration
+	IdleTimeout       time.Duration
+	SendProxyProtocol int // proxyproto.V1 or V2 to prepend a PROXY header, 0 to disable
+}
+
+// Serve accepts connections on ln until it is closed
+func (t *TCPProxy) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		go t.handle(conn)
+	}
+}
+
+func (t *TCPProxy) handle(conn net.Conn) {
+	start := time.Now()
+	clientIP := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+
+	if firewall.IsBlocked(clientIP) {
+		conn.Close()
+		utils.LogRequest(clientIP, "TCP", t.Name, fasthttp.StatusForbidden, time.Since(start))
+		return
+	}
+
+	backendConn, release := t.dial(clientIP)
+	if backendConn == nil {
+		conn.Close()
+		utils.LogRequest(clientIP, "TCP", t.Name, fasthttp.StatusServiceUnavailable, time.Since(start))
+		return
+	}
+	defer release()
+
+	if t.SendProxyProtocol != 0 {
+		backendConn.SetWriteDeadline(time.Now().Add(t.ConnectTimeout))
+		if err := proxyproto.WriteHeader(backendConn, t.SendProxyProtocol, conn.RemoteAddr(), conn.LocalAddr()); err != nil {
+			conn.Close()
+			backendConn.Close()
+			return
+		}
+		backendConn.SetWriteDeadline(time.Time{})
+	}
+
+	splice(conn, backendConn, t.IdleTimeout)
+	utils.LogRequest(clientIP, "TCP", t.Name+" -> "+backendConn.RemoteAddr().String(), fasthttp.StatusOK, time.Since(start))
+}
+
+// dial connects to a backend chosen by the balancer, moving on to the next
+// choice when a connect fails. release must be called when the connection ends.
+func (t *TCPProxy) dial(clientIP string) (net.Conn, func()) {
+	tracker, _ := t.Pool.Balancer.(algorithm.ConnectionTracker)
+	for attempt := 0; attempt < tcpConnectAttempts; attempt++ {
+		backend := t.Pool.Balancer.GetNextBackend(clientIP)
+		if backend == nil {
+			return nil, nil
+		}
+
+		conn, err := net.DialTimeout("tcp", backend.Host, t.ConnectTimeout)
+		if err == nil {
+			return conn, func() { releaseBackend(tracker, backend) }
+		}
+		releaseBackend(tracker, backend)
+	}
+	return nil, nil
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/metrics"
+	"github.com/valyala/fasthttp"
+)
+
+const tunnelBufferSize = 32 * 1024
+
+// dialBackend opens a raw connection to an http:// or https:// backend,
+// sending proxyHeader (if any) ahead of the TLS handshake
+func dialBackend(backend *url.URL, tlsConfig *tls.Config, timeout time.Duration, proxyHeader []byte) (net.Conn, error) {
+	host := backend.Host
+	if backend.Port() == "" {
+		port := "80"
+		if backend.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(backend.Hostname(), port)
+	}
+
+	conn, err := fasthttp.DialTimeout(host, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if proxyHeader != nil {
+		if err := writeProxyHeader(conn, proxyHeader, timeout); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if backend.Scheme != "https" {
+		return conn, nil
+	}
+
+	cfg := &tls.Config{}
+	if tlsConfig != nil {
+		cfg = tlsConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = backend.Hostname()
+	}
+	tlsConn := tls.Client(conn, cfg)
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// splice copies bytes in both directions until either side closes or the
+// tunnel has been idle in both directions for idleTimeout
+func splice(client, backend net.Conn, idleTimeout time.Duration) {
+	metrics.ActiveConnections.Inc()
+	defer metrics.ActiveConnections.Dec()
+
+	var lastActivity atomic.Int64
+	lastActivity.Store(time.Now().UnixNano())
+
+	done := make(chan struct{}, 2)
+	copyConn := func(dst, src net.Conn) {
+		defer func() { done <- struct{}{} }()
+		buf := make([]byte, tunnelBufferSize)
+		for {
+			if idleTimeout > 0 {
+				src.SetReadDeadline(time.Now().Add(idleTimeout))
+			}
+			n, err := src.Read(buf)
+			if n > 0 {
+				lastActivity.Store(time.Now().UnixNano())
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				// Keep waiting while the other direction is still moving data
+				if errors.Is(err, os.ErrDeadlineExceeded) &&
+					time.Since(time.Unix(0, lastActivity.Load())) < idleTimeout {
+					continue
+				}
+				return
+			}
+		}
+	}
+
+	go copyConn(backend, client)
+	go copyConn(client, backend)
+
+	<-done
+	client.Close()
+	backend.Close()
+	<-done
+}
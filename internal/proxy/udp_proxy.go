@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/firewall"
+	"github.com/siddhu949/leanbalancer/internal/metrics"
+	"github.com/siddhu949/leanbalancer/pkg/algorithm"
+	"github.com/siddhu949/leanbalancer/pkg/utils"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	udpMaxDatagram    = 64 * 1024
+	defaultUDPSession = 30 * time.Second
+	defaultMaxSession = 65536
+
+	// How long a resolved backend address is used before it is looked up
+	// again, so backends behind DNS can move
+	udpResolveTTL = 30 * time.Second
+
+	// Pauses after read errors other than a closed socket, so a persistent
+	// one is logged and retried rather than spun on
+	udpErrorBackoff    = 5 * time.Millisecond
+	udpMaxErrorBackoff = time.Second
+)
+
+// UDPProxy forwards datagrams to backends, remembering which backend each
+// client address was sent to so replies find their way back
+type UDPProxy struct {
+	Name           string
+	Pool           *Pool
+	SessionTimeout time.Duration
+	MaxSessions    int
+
+	// Affinity pins a client IP (across source ports) to one backend by hash
+	// instead of using the pool's balancer
+	Affinity bool
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+
+	addrs sync.Map // *resolvedAddr by backend host:port
+}
+
+type resolvedAddr struct {
+	addr    *net.UDPAddr
+	expires time.Time
+}
+
+type udpSession struct {
+	client   *net.UDPAddr
+	backend  *net.UDPConn
+	release  func()
+	lastSeen time.Time
+}
+
+// Serve relays datagrams received on conn until it is closed
+func (u *UDPProxy) Serve(conn *net.UDPConn) error {
+	if u.SessionTimeout <= 0 {
+		u.SessionTimeout = defaultUDPSession
+	}
+	if u.MaxSessions <= 0 {
+		u.MaxSessions = defaultMaxSession
+	}
+	u.sessions = map[string]*udpSession{}
+
+	var balancer algorithm.Balancer = u.Pool.Balancer
+	if u.Affinity {
+		balancer = algorithm.NewIPHash(u.Pool.HealthChecker)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	defer u.closeSessions()
+	go u.expireSessions(stop)
+
+	buf := make([]byte, udpMaxDatagram)
+	var backoff time.Duration
+	for {
+		n, client, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			backoff = nextBackoff(backoff)
+			log.Printf("UDP %s: read error, retrying in %s: %s", u.Name, backoff, err)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+
+		s := u.session(conn, client, balancer)
+		if s == nil {
+			continue
+		}
+		if _, err := s.backend.Write(buf[:n]); err != nil {
+			metrics.UDPWriteErrors.WithLabelValues(u.Name, "backend").Inc()
+		}
+	}
+}
+
+// nextBackoff doubles the pause after a read error, up to a second
+func nextBackoff(d time.Duration) time.Duration {
+	if d == 0 {
+		return udpErrorBackoff
+	}
+	return min(2*d, udpMaxErrorBackoff)
+}
+
+// session returns the client's session, creating one on first contact.
+// Backends are dialed without holding the lock, so replies and expiry
+// carry on meanwhile.
+func (u *UDPProxy) session(conn *net.UDPConn, client *net.UDPAddr, balancer algorithm.Balancer) *udpSession {
+	key := client.String()
+
+	u.mu.Lock()
+	if s, ok := u.sessions[key]; ok {
+		s.lastSeen = time.Now()
+		u.mu.Unlock()
+		return s
+	}
+	full := len(u.sessions) >= u.MaxSessions
+	u.mu.Unlock()
+
+	clientIP := client.IP.String()
+	if full || firewall.IsBlocked(clientIP) {
+		return nil
+	}
+
+	backend := balancer.GetNextBackend(clientIP)
+	if backend == nil {
+		utils.LogRequest(clientIP, "UDP", u.Name, fasthttp.StatusServiceUnavailable, 0)
+		return nil
+	}
+	tracker, _ := balancer.(algorithm.ConnectionTracker)
+
+	addr, err := u.resolve(backend.Host)
+	if err != nil {
+		log.Printf("UDP %s: resolving backend %s: %s", u.Name, backend.Host, err)
+		releaseBackend(tracker, backend)
+		return nil
+	}
+	backendConn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		log.Printf("UDP %s: dialing backend %s: %s", u.Name, addr, err)
+		u.addrs.Delete(backend.Host) // Look it up again next time
+		releaseBackend(tracker, backend)
+		return nil
+	}
+	s := &udpSession{
+		client:   client,
+		backend:  backendConn,
+		release:  func() { releaseBackend(tracker, backend) },
+		lastSeen: time.Now(),
+	}
+
+	u.mu.Lock()
+	u.sessions[key] = s
+	u.mu.Unlock()
+	metrics.ActiveConnections.Inc()
+	go u.relayReplies(conn, s)
+	return s
+}
+
+// resolve looks up a backend address and remembers it for udpResolveTTL.
+// Sessions keep the address they were dialed with.
+func (u *UDPProxy) resolve(host string) (*net.UDPAddr, error) {
+	now := time.Now()
+	if v, ok := u.addrs.Load(host); ok && now.Before(v.(*resolvedAddr).expires) {
+		return v.(*resolvedAddr).addr, nil
+	}
+	addr, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	u.addrs.Store(host, &resolvedAddr{addr: addr, expires: now.Add(udpResolveTTL)})
+	return addr, nil
+}
+
+// relayReplies sends backend datagrams back to the client until the session is closed
+func (u *UDPProxy) relayReplies(conn *net.UDPConn, s *udpSession) {
+	buf := make([]byte, udpMaxDatagram)
+	var backoff time.Duration
+	for {
+		n, err := s.backend.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// e.g. ICMP port unreachable; keep the session until it expires
+			backoff = nextBackoff(backoff)
+			log.Printf("UDP %s: backend %s read error, retrying in %s: %s", u.Name, s.backend.RemoteAddr(), backoff, err)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+		if _, err := conn.WriteToUDP(buf[:n], s.client); err != nil {
+			metrics.UDPWriteErrors.WithLabelValues(u.Name, "client").Inc()
+		}
+
+		u.mu.Lock()
+		s.lastSeen = time.Now()
+		u.mu.Unlock()
+	}
+}
+
+// expireSessions drops sessions idle for longer than SessionTimeout
+func (u *UDPProxy) expireSessions(stop chan struct{}) {
+	ticker := time.NewTicker(u.SessionTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			u.mu.Lock()
+			for key, s := range u.sessions {
+				if now.Sub(s.lastSeen) > u.SessionTimeout {
+					u.closeSession(key, s)
+				}
+			}
+			u.mu.Unlock()
+		}
+	}
+}
+
+// closeSessions ends every session once Serve returns
+func (u *UDPProxy) closeSessions() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for key, s := range u.sessions {
+		u.closeSession(key, s)
+	}
+}
+
+// closeSession closes the backend socket, which stops relayReplies, and
+// releases the backend; u.mu must be held
+func (u *UDPProxy) closeSession(key string, s *udpSession) {
+	delete(u.sessions, key)
+	s.backend.Close()
+	s.release()
+	metrics.ActiveConnections.Dec()
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// udpEcho starts a UDP server that answers every datagram with name:payload
+func udpEcho(t *testing.T, name string) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, udpMaxDatagram)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(append([]byte(name+":"), buf[:n]...), from)
+		}
+	}()
+	return "udp://" + conn.LocalAddr().String()
+}
+
+// serveUDP runs u on a local socket and returns its address
+func serveUDP(t *testing.T, u *UDPProxy) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		u.Serve(conn)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		conn.Close()
+		<-done
+	})
+	return conn
+}
+
+// udpExchange sends payload from client to the proxy and returns the reply
+func udpExchange(t *testing.T, client *net.UDPConn, proxy net.Addr, payload string) string {
+	t.Helper()
+	if _, err := client.WriteTo([]byte(payload), proxy); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, udpMaxDatagram)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("no reply to %q: %v", payload, err)
+	}
+	return string(buf[:n])
+}
+
+func udpClient(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func (u *UDPProxy) sessionCount() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.sessions)
+}
+
+func TestUDPRepliesReachTheirClient(t *testing.T) {
+	u := &UDPProxy{Name: "test", Pool: NewPool("udp", []string{udpEcho(t, "a")}, "round_robin", time.Second, nil)}
+	proxy := serveUDP(t, u).LocalAddr()
+
+	first, second := udpClient(t), udpClient(t)
+	for i := 0; i < 3; i++ {
+		if got := udpExchange(t, first, proxy, "one"); got != "a:one" {
+			t.Errorf("first client got %q", got)
+		}
+		if got := udpExchange(t, second, proxy, "two"); got != "a:two" {
+			t.Errorf("second client got %q", got)
+		}
+	}
+	if n := u.sessionCount(); n != 2 {
+		t.Errorf("sessions = %d, want 2", n)
+	}
+}
+
+func TestUDPIdleSessionsExpire(t *testing.T) {
+	u := &UDPProxy{
+		Name:           "test",
+		Pool:           NewPool("udp", []string{udpEcho(t, "a")}, "round_robin", time.Second, nil),
+		SessionTimeout: 100 * time.Millisecond,
+	}
+	proxy := serveUDP(t, u).LocalAddr()
+	client := udpClient(t)
+
+	udpExchange(t, client, proxy, "ping")
+	if n := u.sessionCount(); n != 1 {
+		t.Fatalf("sessions = %d, want 1", n)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if n := u.sessionCount(); n != 0 {
+		t.Fatalf("sessions after idle timeout = %d, want 0", n)
+	}
+	// The client is given a new session
+	if got := udpExchange(t, client, proxy, "again"); got != "a:again" {
+		t.Errorf("reply after expiry = %q", got)
+	}
+}
+
+func TestUDPAffinityPinsClientIP(t *testing.T) {
+	backends := []string{udpEcho(t, "a"), udpEcho(t, "b"), udpEcho(t, "c")}
+	for _, affinity := range []bool{true, false} {
+		u := &UDPProxy{Name: "test", Pool: NewPool("udp", backends, "round_robin", time.Second, nil), Affinity: affinity}
+		proxy := serveUDP(t, u).LocalAddr()
+
+		// Each client socket has its own source port but the same IP
+		seen := map[string]bool{}
+		for i := 0; i < 6; i++ {
+			reply := udpExchange(t, udpClient(t), proxy, "x")
+			seen[strings.SplitN(reply, ":", 2)[0]] = true
+		}
+		if affinity && len(seen) != 1 {
+			t.Errorf("with affinity, one client IP reached %d backends", len(seen))
+		}
+		if !affinity && len(seen) != len(backends) {
+			t.Errorf("without affinity, round robin reached %d of %d backends", len(seen), len(backends))
+		}
+	}
+}
+
+func TestUDPServeClosesSessionsOnReturn(t *testing.T) {
+	u := &UDPProxy{Name: "test", Pool: NewPool("udp", []string{udpEcho(t, "a")}, "round_robin", time.Second, nil)}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		u.Serve(conn)
+		close(done)
+	}()
+
+	udpExchange(t, udpClient(t), conn.LocalAddr(), "one")
+	udpExchange(t, udpClient(t), conn.LocalAddr(), "two")
+	conn.Close()
+	<-done
+	if n := u.sessionCount(); n != 0 {
+		t.Errorf("sessions after Serve returned = %d, want 0", n)
+	}
+}
+
+func TestUDPResolveExpires(t *testing.T) {
+	u := &UDPProxy{Name: "dns"}
+	first, err := u.resolve("127.0.0.1:5353")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := u.resolve("127.0.0.1:5353"); again != first {
+		t.Error("a fresh address was looked up again")
+	}
+
+	// An address past its TTL is looked up again
+	stale := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+	u.addrs.Store("127.0.0.1:5353", &resolvedAddr{addr: stale, expires: time.Now().Add(-time.Second)})
+	if addr, _ := u.resolve("127.0.0.1:5353"); addr == stale || !addr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("expired address resolved to %s", addr)
+	}
+}
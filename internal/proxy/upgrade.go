@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/errorpages"
+	"github.com/siddhu949/leanbalancer/internal/minrate"
+	"github.com/siddhu949/leanbalancer/internal/realip"
+	"github.com/siddhu949/leanbalancer/internal/rewrite"
+	"github.com/siddhu949/leanbalancer/pkg/utils"
+	"github.com/valyala/fasthttp"
+)
+
+// Idle timeout for upgraded (WebSocket) connections
+var upgradeIdleTimeout = 5 * time.Minute
+
+// SetUpgradeIdleTimeout sets how long an upgraded connection may sit idle
+func SetUpgradeIdleTimeout(d time.Duration) {
+	upgradeIdleTimeout = d
+}
+
+// isUpgradeRequest reports whether the request asks for Connection: Upgrade
+func isUpgradeRequest(ctx *fasthttp.RequestCtx) bool {
+	if len(ctx.Request.Header.Peek(fasthttp.HeaderUpgrade)) == 0 {
+		return false
+	}
+	for _, token := range bytes.Split(ctx.Request.Header.Peek(fasthttp.HeaderConnection), []byte(",")) {
+		if bytes.EqualFold(bytes.TrimSpace(token), []byte("upgrade")) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyUpgrade forwards the upgrade handshake to backend, then hijacks the
+// client connection and splices bytes until either side goes away.
+// release is called once the tunnel closes.
+func proxyUpgrade(ctx *fasthttp.RequestCtx, p *Pool, backend *url.URL, target *rewrite.Result, release func()) {
+	start := time.Now()
+	clientIP := realip.ClientIP(ctx)
+
+	conn, err := dialBackend(backend, p.TLSConfig, p.Timeout, p.proxyHeader(ctx))
+	if err != nil {
+		release()
+		errorpages.Error(ctx, fmt.Sprintf("Error forwarding request: %s", err), fasthttp.StatusBadGateway)
+		return
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	ctx.Request.CopyTo(req)
+	uri := target.URI()
+	req.SetRequestURI(uri)
+	req.Header.SetHost(backend.Host)
+	if target.Host != "" {
+		req.Header.SetHost(target.Host)
+	}
+
+	bw := bufio.NewWriter(conn)
+	if err := req.Write(bw); err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		release()
+		errorpages.Error(ctx, fmt.Sprintf("Error forwarding request: %s", err), fasthttp.StatusBadGateway)
+		return
+	}
+
+	// The backend's own response (101 or an error) is relayed byte for byte
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(clientConn net.Conn) {
+		minrate.Hijacked(clientConn)
+		defer release()
+		splice(clientConn, conn, upgradeIdleTimeout)
+		utils.LogRequest(clientIP, "UPGRADE", uri, fasthttp.StatusSwitchingProtocols, time.Since(start))
+	})
+}
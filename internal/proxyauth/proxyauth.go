@@ -0,0 +1,238 @@
+package proxyauth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/ratelimit"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrMissingCredentials = errors.New("proxy credentials required")
+	ErrInvalidCredentials = errors.New("invalid proxy credentials")
+)
+
+// User is an authenticated proxy user
+type User struct {
+	Name         string
+	AllowedHosts []string           // Destination host patterns; any host when empty
+	Limiter      *ratelimit.Limiter // Keyed by Name; nil when the user is not rate limited
+}
+
+// Settings configures an Authenticator
+type Settings struct {
+	Realm        string
+	HtpasswdFile string            // user:bcrypt-hash lines
+	Tokens       map[string]string // Bearer token -> user name
+	Users        []UserSettings
+}
+
+// UserSettings holds the optional restrictions for one user
+type UserSettings struct {
+	Name         string
+	AllowedHosts []string
+	RateLimit    float64 // Requests per second; 0 is unlimited
+	Burst        int
+}
+
+// Authenticator checks Proxy-Authorization headers
+type Authenticator struct {
+	Realm string
+
+	passwords map[string][]byte            // user -> bcrypt hash
+	tokens    map[[sha256.Size]byte]string // sha256(token) -> user
+	users     map[string]*User
+
+	// Unknown users are checked against this hash, so they take as long to
+	// reject as a wrong password
+	dummyHash []byte
+
+	// bcrypt is deliberately slow, so the last password that verified for
+	// each user is remembered, as an HMAC under a key that never leaves the
+	// process, and compared directly
+	cacheKey []byte
+	mu       sync.Mutex
+	verified map[string][sha256.Size]byte
+}
+
+// NewAuthenticator loads the htpasswd file and builds the user table
+func NewAuthenticator(s Settings) (*Authenticator, error) {
+	a := &Authenticator{
+		Realm:     s.Realm,
+		passwords: map[string][]byte{},
+		tokens:    map[[sha256.Size]byte]string{},
+		users:     map[string]*User{},
+		cacheKey:  make([]byte, sha256.Size),
+		verified:  map[string][sha256.Size]byte{},
+	}
+	if _, err := rand.Read(a.cacheKey); err != nil {
+		return nil, err
+	}
+	if a.Realm == "" {
+		a.Realm = "LeanBalancer"
+	}
+
+	if s.HtpasswdFile != "" {
+		passwords, err := LoadHtpasswd(s.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		a.passwords = passwords
+	}
+	dummy, err := newDummyHash(a.passwords)
+	if err != nil {
+		return nil, err
+	}
+	a.dummyHash = dummy
+	for token, user := range s.Tokens {
+		if token == "" || user == "" {
+			return nil, errors.New("bearer tokens need a token and a user")
+		}
+		a.tokens[sha256.Sum256([]byte(token))] = user
+	}
+
+	for _, us := range s.Users {
+		u := &User{Name: us.Name}
+		for _, h := range us.AllowedHosts {
+			u.AllowedHosts = append(u.AllowedHosts, strings.ToLower(strings.TrimSpace(h)))
+		}
+		if us.RateLimit > 0 {
+			u.Limiter = userLimiter(us.RateLimit, us.Burst)
+		}
+		a.users[us.Name] = u
+	}
+	return a, nil
+}
+
+// userLimiter allows rate requests per second, fractions included, with up
+// to burst at once; burst defaults to one second's worth
+func userLimiter(rate float64, burst int) *ratelimit.Limiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return ratelimit.NewLimiter(1, time.Duration(float64(time.Second)/rate), burst, 1)
+}
+
+// newDummyHash hashes a random password at the highest cost in passwords,
+// or bcrypt's default when there are none
+func newDummyHash(passwords map[string][]byte) ([]byte, error) {
+	cost := bcrypt.DefaultCost
+	if len(passwords) > 0 {
+		cost = bcrypt.MinCost
+		for _, hash := range passwords {
+			if c, _ := bcrypt.Cost(hash); c > cost {
+				cost = c
+			}
+		}
+	}
+	password := make([]byte, 16)
+	if _, err := rand.Read(password); err != nil {
+		return nil, err
+	}
+	return bcrypt.GenerateFromPassword(password, cost)
+}
+
+// LoadHtpasswd reads user:hash lines; only bcrypt hashes ($2a$, $2b$, $2y$) are accepted
+func LoadHtpasswd(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	passwords := map[string][]byte{}
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", path, lineNo)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: user %q needs a bcrypt hash (htpasswd -B)", path, lineNo, user)
+		}
+		passwords[user] = []byte(hash)
+	}
+	return passwords, scanner.Err()
+}
+
+// Authenticate checks a Proxy-Authorization header value, either
+// "Basic base64(user:password)" or "Bearer token"
+func (a *Authenticator) Authenticate(header []byte) (*User, error) {
+	scheme, credentials, _ := bytes.Cut(bytes.TrimSpace(header), []byte(" "))
+	credentials = bytes.TrimSpace(credentials)
+	if len(credentials) == 0 {
+		return nil, ErrMissingCredentials
+	}
+
+	switch strings.ToLower(string(scheme)) {
+	case "basic":
+		decoded, err := base64.StdEncoding.DecodeString(string(credentials))
+		if err != nil {
+			return nil, ErrInvalidCredentials
+		}
+		name, password, ok := strings.Cut(string(decoded), ":")
+		if !ok || !a.checkPassword(name, password) {
+			return nil, ErrInvalidCredentials
+		}
+		return a.user(name), nil
+
+	case "bearer":
+		name, ok := a.tokens[sha256.Sum256(credentials)]
+		if !ok {
+			return nil, ErrInvalidCredentials
+		}
+		return a.user(name), nil
+	}
+	return nil, ErrMissingCredentials
+}
+
+func (a *Authenticator) checkPassword(name, password string) bool {
+	hash, ok := a.passwords[name]
+	if !ok {
+		bcrypt.CompareHashAndPassword(a.dummyHash, []byte(password))
+		return false
+	}
+	mac := hmac.New(sha256.New, a.cacheKey)
+	mac.Write([]byte(password))
+	var sum [sha256.Size]byte
+	mac.Sum(sum[:0])
+
+	a.mu.Lock()
+	cached, ok := a.verified[name]
+	a.mu.Unlock()
+	if ok && hmac.Equal(cached[:], sum[:]) {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+	a.mu.Lock()
+	a.verified[name] = sum
+	a.mu.Unlock()
+	return true
+}
+
+// user returns the configured restrictions for name, if any
+func (a *Authenticator) user(name string) *User {
+	if u, ok := a.users[name]; ok {
+		return u
+	}
+	return &User{Name: name}
+}
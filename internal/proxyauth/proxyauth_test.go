@@ -0,0 +1,84 @@
+package proxyauth
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestAuthenticator writes an htpasswd file for alice:secret
+func newTestAuthenticator(t *testing.T, users ...UserSettings) *Authenticator {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, append([]byte("alice:"), hash...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAuthenticator(Settings{HtpasswdFile: path, Users: users})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func basic(user, password string) []byte {
+	return []byte("Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password)))
+}
+
+func TestAuthenticateBasic(t *testing.T) {
+	a := newTestAuthenticator(t)
+	for i := 0; i < 2; i++ { // The second time is answered from the cache
+		if u, err := a.Authenticate(basic("alice", "secret")); err != nil || u.Name != "alice" {
+			t.Fatalf("alice: got %v, %v", u, err)
+		}
+	}
+	if _, err := a.Authenticate(basic("alice", "wrong")); err != ErrInvalidCredentials {
+		t.Errorf("wrong password: got %v", err)
+	}
+	if _, err := a.Authenticate(basic("mallory", "secret")); err != ErrInvalidCredentials {
+		t.Errorf("unknown user: got %v", err)
+	}
+}
+
+func TestUnknownUsersPayForBcrypt(t *testing.T) {
+	a := newTestAuthenticator(t)
+	if cost, err := bcrypt.Cost(a.dummyHash); err != nil || cost != bcrypt.MinCost {
+		t.Errorf("dummy hash cost %d (%v), want the htpasswd cost %d", cost, err, bcrypt.MinCost)
+	}
+}
+
+func TestPasswordCacheIsKeyedPerProcess(t *testing.T) {
+	a, b := newTestAuthenticator(t), newTestAuthenticator(t)
+	a.Authenticate(basic("alice", "secret"))
+	b.Authenticate(basic("alice", "secret"))
+	if a.verified["alice"] == b.verified["alice"] {
+		t.Error("the same password has the same cache entry under different keys")
+	}
+}
+
+func TestUserRateLimit(t *testing.T) {
+	a := newTestAuthenticator(t, UserSettings{Name: "alice", RateLimit: 0.5, Burst: 2})
+	u, err := a.Authenticate(basic("alice", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if r := u.Limiter.Allow(u.Name); !r.Allowed {
+			t.Fatalf("request %d within the burst was denied", i+1)
+		}
+	}
+	r := u.Limiter.Allow(u.Name)
+	if r.Allowed {
+		t.Fatal("request beyond the burst was allowed")
+	}
+	if r.RetryAfter <= time.Second || r.RetryAfter > 2*time.Second {
+		t.Errorf("retry after %v, want about 2s at 0.5 requests per second", r.RetryAfter)
+	}
+}
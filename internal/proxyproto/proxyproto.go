@@ -0,0 +1,357 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Protocol versions
+const (
+	V1 = 1
+	V2 = 2
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	// ErrNoHeader is returned when a required PROXY header is missing
+	ErrNoHeader = errors.New("proxyproto: missing PROXY protocol header")
+)
+
+const (
+	v1MaxLength     = 107 // Longest valid v1 line including CRLF
+	v2HeaderLength  = 16
+	defaultDeadline = 5 * time.Second
+)
+
+// Listener parses PROXY protocol headers on accepted connections
+type Listener struct {
+	net.Listener
+
+	// Trusted decides which peers may send a PROXY header; nil trusts everyone.
+	// Connections from untrusted peers are passed through untouched.
+	Trusted func(ip net.IP) bool
+
+	// Required rejects trusted connections that do not start with a header
+	Required bool
+
+	// HeaderTimeout bounds how long we wait for the header
+	HeaderTimeout time.Duration
+
+	startOnce sync.Once
+	conns     chan net.Conn
+	errs      chan error
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewListener wraps l so accepted connections report the PROXY source address
+func NewListener(l net.Listener, trusted func(ip net.IP) bool, required bool) *Listener {
+	return &Listener{
+		Listener:      l,
+		Trusted:       trusted,
+		Required:      required,
+		HeaderTimeout: defaultDeadline,
+		conns:         make(chan net.Conn),
+		errs:          make(chan error, 1),
+		done:          make(chan struct{}),
+	}
+}
+
+// Accept returns the next connection whose header has been read. Headers are
+// read on a goroutine per connection, so a client that stays silent cannot
+// stall the connections behind it.
+func (l *Listener) Accept() (net.Conn, error) {
+	l.startOnce.Do(func() { go l.acceptLoop() })
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+func (l *Listener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			l.errs <- err
+			return
+		}
+		go l.dispatch(conn)
+	}
+}
+
+// dispatch reads the header, if the peer may send one, and hands the
+// connection to Accept. Connections with a bad or missing required header
+// are closed.
+func (l *Listener) dispatch(conn net.Conn) {
+	trusted := true
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && l.Trusted != nil {
+		trusted = l.Trusted(tcpAddr.IP)
+	}
+	if trusted {
+		c, err := l.readHeader(conn)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		conn = c
+	}
+
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *Listener) readHeader(conn net.Conn) (*Conn, error) {
+	if l.HeaderTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(l.HeaderTimeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	c := &Conn{Conn: conn, reader: bufio.NewReader(conn)}
+	var err error
+	c.remoteAddr, c.localAddr, err = ReadHeader(c.reader)
+	if err == ErrNoHeader && !l.Required {
+		err = nil
+	}
+	return c, err
+}
+
+// Conn is a connection whose PROXY protocol header has been read
+type Conn struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+// Read reads from the connection after the PROXY header
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address from the header, or the peer address
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address from the header, or the local address
+func (c *Conn) LocalAddr() net.Addr {
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ReadHeader consumes a v1 or v2 header from r and returns the source and
+// destination addresses. Both are nil for LOCAL/UNKNOWN headers.
+// ErrNoHeader is returned, with nothing consumed, if r does not start with a header.
+func ReadHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	peek, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		// A short read that already diverges from both signatures is plain traffic
+		if len(peek) > 0 && !bytes.HasPrefix(v2Signature, peek) && !bytes.HasPrefix(v1Prefix, peek) {
+			return nil, nil, ErrNoHeader
+		}
+		return nil, nil, err
+	}
+	if bytes.Equal(peek, v1Prefix) {
+		return readV1(r)
+	}
+	if !bytes.Equal(peek, v2Signature[:len(peek)]) {
+		return nil, nil, ErrNoHeader
+	}
+	peek, err = r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(peek, v2Signature) {
+		return nil, nil, ErrNoHeader
+	}
+	return readV2(r)
+}
+
+func readV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("proxyproto: v1 header too long or not CRLF terminated")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("proxyproto: malformed v1 header %q", line)
+	}
+
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || err1 != nil || err2 != nil {
+		return nil, nil, fmt.Errorf("proxyproto: malformed v1 header %q", line)
+	}
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+}
+
+func readV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var hdr [v2HeaderLength]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("proxyproto: unsupported v2 version %d", hdr[12]>>4)
+	}
+	command := hdr[12] & 0x0f
+	family := hdr[13]
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL connections (health checks from the balancer itself) keep the peer address
+	if command == 0 {
+		return nil, nil, nil
+	}
+	if command != 1 {
+		return nil, nil, fmt.Errorf("proxyproto: unsupported v2 command %d", command)
+	}
+
+	var ipLen int
+	switch family >> 4 {
+	case 1:
+		ipLen = net.IPv4len
+	case 2:
+		ipLen = net.IPv6len
+	default:
+		return nil, nil, nil // AF_UNSPEC / AF_UNIX carry no usable IP address
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, nil, fmt.Errorf("proxyproto: short v2 address block")
+	}
+
+	srcIP := net.IP(append([]byte(nil), payload[:ipLen]...))
+	dstIP := net.IP(append([]byte(nil), payload[ipLen:2*ipLen]...))
+	srcPort := int(binary.BigEndian.Uint16(payload[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(payload[2*ipLen+2:]))
+
+	if family&0x0f == 2 { // DGRAM
+		return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}, nil
+	}
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}, nil
+}
+
+// WriteHeader writes a PROXY protocol header describing src -> dst to w.
+// It is sent once, before any payload, on connections to backends.
+func WriteHeader(w io.Writer, version int, src, dst net.Addr) error {
+	srcIP, srcPort, srcUDP := splitAddr(src)
+	dstIP, dstPort, _ := splitAddr(dst)
+	if srcIP == nil || dstIP == nil {
+		if version == V1 {
+			_, err := io.WriteString(w, "PROXY UNKNOWN\r\n")
+			return err
+		}
+		_, err := w.Write(append(append([]byte(nil), v2Signature...), 0x20, 0x00, 0x00, 0x00))
+		return err
+	}
+
+	ipv4 := srcIP.To4() != nil && dstIP.To4() != nil
+	switch version {
+	case V1:
+		proto := "TCP6"
+		if ipv4 {
+			proto = "TCP4"
+		}
+		_, err := fmt.Fprintf(w, "PROXY %s %s %s %d %d\r\n", proto, srcIP, dstIP, srcPort, dstPort)
+		return err
+
+	case V2:
+		buf := append([]byte(nil), v2Signature...)
+		family := byte(0x20) // AF_INET6
+		if ipv4 {
+			family = 0x10 // AF_INET
+			srcIP, dstIP = srcIP.To4(), dstIP.To4()
+		} else {
+			srcIP, dstIP = srcIP.To16(), dstIP.To16()
+		}
+		if srcUDP {
+			family |= 0x02
+		} else {
+			family |= 0x01
+		}
+		buf = append(buf, 0x21, family)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(2*len(srcIP)+4))
+		buf = append(buf, srcIP...)
+		buf = append(buf, dstIP...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(srcPort))
+		buf = binary.BigEndian.AppendUint16(buf, uint16(dstPort))
+		_, err := w.Write(buf)
+		return err
+
+	default:
+		return fmt.Errorf("proxyproto: unsupported version %d", version)
+	}
+}
+
+// ParseVersion maps a config value ("v1", "v2" or "") to a protocol version, 0 meaning disabled
+func ParseVersion(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "", "off", "none":
+		return 0, nil
+	case "v1", "1":
+		return V1, nil
+	case "v2", "2":
+		return V2, nil
+	default:
+		return 0, fmt.Errorf("proxyproto: unknown version %q", s)
+	}
+}
+
+func splitAddr(addr net.Addr) (net.IP, int, bool) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP, a.Port, false
+	case *net.UDPAddr:
+		return a.IP, a.Port, true
+	default:
+		return nil, 0, false
+	}
+}
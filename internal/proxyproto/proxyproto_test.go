@@ -0,0 +1,112 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T, required bool) *Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(ln, nil, required)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func dial(t *testing.T, l net.Listener) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestSilentClientDoesNotStallAccept(t *testing.T) {
+	l := listen(t, true)
+	dial(t, l) // Connects and never sends a header
+
+	conn := dial(t, l)
+	io.WriteString(conn, "PROXY TCP4 192.0.2.1 192.0.2.2 4000 80\r\nGET / HTTP/1.1\r\n\r\n")
+
+	// Like fasthttp's accept loop with MaxConnsPerIP, which reads RemoteAddr
+	// of every connection before accepting the next
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if c.RemoteAddr().String() == "192.0.2.1:4000" {
+				accepted <- c
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	select {
+	case c := <-accepted:
+		defer c.Close()
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		if line != "GET / HTTP/1.1\r\n" {
+			t.Errorf("payload after header = %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept blocked behind a silent client")
+	}
+}
+
+func TestMissingRequiredHeaderIsClosed(t *testing.T) {
+	l := listen(t, true)
+	l.HeaderTimeout = 100 * time.Millisecond
+	go l.Accept()
+
+	conn := dial(t, l)
+	io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n")
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read after missing header: %v, want EOF", err)
+	}
+}
+
+func TestOptionalHeaderPassesPlainTraffic(t *testing.T) {
+	l := listen(t, false)
+	conn := dial(t, l)
+	io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n")
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.RemoteAddr().String() != conn.LocalAddr().String() {
+		t.Errorf("RemoteAddr = %s, want the peer %s", c.RemoteAddr(), conn.LocalAddr())
+	}
+}
+
+func TestWriteHeaderRoundTrip(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000}
+	dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}
+	for _, version := range []int{V1, V2} {
+		var buf bytes.Buffer
+		if err := WriteHeader(&buf, version, src, dst); err != nil {
+			t.Fatal(err)
+		}
+		gotSrc, gotDst, err := ReadHeader(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+		if gotSrc.String() != src.String() || gotDst.String() != dst.String() {
+			t.Errorf("v%d: got %s -> %s", version, gotSrc, gotDst)
+		}
+	}
+}
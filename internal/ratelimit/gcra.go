@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/shardmap"
+)
+
+// Limiter allows requests per key at a sustained rate of requests per
+// period, with up to burst at once. It implements GCRA: each key stores
+// only the time at which its allowance will be fully restored, in a map
+// capped at MaxKeys that evicts the least recently seen key.
+type Limiter struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+	MaxKeys  int
+
+	interval  time.Duration // Between requests at the sustained rate
+	tolerance time.Duration // How far ahead of the rate a key may run
+
+	tat       *shardmap.Map[time.Time] // Theoretical arrival time per key
+	now       func() time.Time         // time.Now, except in tests
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// Result is the outcome of a request against a limiter
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // Until the key's allowance is full again
+	RetryAfter time.Duration // Until the next request is allowed, if denied
+}
+
+// DefaultMaxKeys is how many keys a limiter tracks unless told otherwise
+const DefaultMaxKeys = 100000
+
+// NewLimiter creates a limiter; burst defaults to requests and maxKeys to
+// DefaultMaxKeys. Keys that have recovered their full allowance are swept
+// once a minute until Close.
+func NewLimiter(requests int, period time.Duration, burst, maxKeys int) *Limiter {
+	if burst < 1 {
+		burst = requests
+	}
+	if maxKeys < 1 {
+		maxKeys = DefaultMaxKeys
+	}
+	interval := period / time.Duration(requests)
+	if interval <= 0 {
+		interval = 1 // More requests than nanoseconds in the period
+	}
+	l := &Limiter{
+		Requests:  requests,
+		Period:    period,
+		Burst:     burst,
+		MaxKeys:   maxKeys,
+		interval:  interval,
+		tolerance: interval * time.Duration(burst-1),
+		tat:       shardmap.New[time.Time](maxKeys),
+		now:       time.Now,
+		stop:      make(chan struct{}),
+	}
+	go l.sweep(time.Minute)
+	return l
+}
+
+// Allow counts a request for key
+func (l *Limiter) Allow(key string) Result {
+	var r Result
+	l.tat.Update(key, func(tat time.Time, _ bool) (time.Time, bool) {
+		r, tat = l.allow(l.now(), tat)
+		return tat, r.Allowed
+	})
+	return r
+}
+
+// allow decides a request given the key's theoretical arrival time and
+// returns the new one
+func (l *Limiter) allow(now, tat time.Time) (Result, time.Time) {
+	if tat.Before(now) {
+		tat = now
+	}
+
+	if allowAt := tat.Add(-l.tolerance); now.Before(allowAt) {
+		return Result{Limit: l.Burst, Reset: tat.Sub(now), RetryAfter: allowAt.Sub(now)}, tat
+	}
+
+	tat = tat.Add(l.interval)
+	// Requests that could still be sent right now
+	remaining := 0
+	if ahead := now.Add(l.tolerance).Sub(tat); ahead >= 0 {
+		remaining = int(ahead/l.interval) + 1
+	}
+	return Result{Allowed: true, Limit: l.Burst, Remaining: remaining, Reset: tat.Sub(now)}, tat
+}
+
+// sweep forgets keys whose allowance is full, which is the same as never having seen them
+func (l *Limiter) sweep(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			l.tat.DeleteFunc(func(_ string, tat time.Time) bool {
+				return tat.Before(now)
+			})
+		}
+	}
+}
+
+// Close stops sweeping. The limiter still answers Allow, but keys are then
+// only dropped by eviction.
+func (l *Limiter) Close() {
+	l.closeOnce.Do(func() { close(l.stop) })
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is a limiter clock that only moves when told to
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func testLimiter(t *testing.T, requests int, period time.Duration, burst int) (*Limiter, *fakeClock) {
+	t.Helper()
+	l := NewLimiter(requests, period, burst, 0)
+	t.Cleanup(l.Close)
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	l.now = clock.Now
+	return l, clock
+}
+
+func TestLimiterAllow(t *testing.T) {
+	// 10 per second is one every 100ms, with 3 at once
+	l, clock := testLimiter(t, 10, time.Second, 3)
+	for _, step := range []struct {
+		after     time.Duration // Since the previous step
+		allowed   bool
+		remaining int
+		reset     time.Duration
+		retry     time.Duration
+	}{
+		{0, true, 2, 100 * time.Millisecond, 0},
+		{0, true, 1, 200 * time.Millisecond, 0},
+		{0, true, 0, 300 * time.Millisecond, 0},
+		{0, false, 0, 300 * time.Millisecond, 100 * time.Millisecond},
+		{50 * time.Millisecond, false, 0, 250 * time.Millisecond, 50 * time.Millisecond},
+		{50 * time.Millisecond, true, 0, 300 * time.Millisecond, 0}, // One interval restored
+		{0, false, 0, 300 * time.Millisecond, 100 * time.Millisecond},
+		{time.Second, true, 2, 100 * time.Millisecond, 0}, // Fully restored
+	} {
+		clock.Advance(step.after)
+		r := l.Allow("k")
+		if r.Allowed != step.allowed || r.Remaining != step.remaining || r.Reset != step.reset || r.RetryAfter != step.retry || r.Limit != 3 {
+			t.Fatalf("at %s: got %+v, want allowed %v, remaining %d, reset %s, retry after %s",
+				clock.now.Format("15:04:05.000"), r, step.allowed, step.remaining, step.reset, step.retry)
+		}
+	}
+}
+
+func TestLimiterKeysAreIndependent(t *testing.T) {
+	l, _ := testLimiter(t, 1, time.Minute, 1)
+	if !l.Allow("a").Allowed || !l.Allow("b").Allowed {
+		t.Fatal("first request of each key was denied")
+	}
+	if l.Allow("a").Allowed {
+		t.Error("second request of a was allowed")
+	}
+}
+
+func TestLimiterBurstDefaultsToRequests(t *testing.T) {
+	l, clock := testLimiter(t, 5, time.Minute, 0)
+	for i := 0; i < 5; i++ {
+		if !l.Allow("k").Allowed {
+			t.Fatalf("request %d of the burst was denied", i+1)
+		}
+	}
+	if r := l.Allow("k"); r.Allowed || r.RetryAfter != 12*time.Second {
+		t.Fatalf("over the burst: got %+v", r)
+	}
+	clock.Advance(12 * time.Second)
+	if !l.Allow("k").Allowed {
+		t.Error("denied once an interval had passed")
+	}
+}
+
+func TestLimiterShortPeriod(t *testing.T) {
+	// More requests than nanoseconds must not divide by zero
+	l, _ := testLimiter(t, 1000, time.Nanosecond, 1)
+	if !l.Allow("k").Allowed {
+		t.Error("first request was denied")
+	}
+}
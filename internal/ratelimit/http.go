@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/siddhu949/leanbalancer/internal/realip"
+	"github.com/valyala/fasthttp"
+)
+
+// KeyFunc returns the key a request is limited by
+type KeyFunc func(ctx *fasthttp.RequestCtx) string
+
+// ParseKey builds a KeyFunc from "ip", "path", "header:<name>" or
+// "jwt:<claim>". Requests without the header or claim fall back to their
+// client IP. JWT claims are read without verifying the token, so they
+// should only be trusted behind something that does.
+func ParseKey(spec string) (KeyFunc, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "ip":
+		return ClientIPKey, nil
+	case "path":
+		return func(ctx *fasthttp.RequestCtx) string {
+			return "path:" + string(ctx.Path())
+		}, nil
+	case "header":
+		if arg == "" {
+			return nil, fmt.Errorf("rate limit key %q: missing header name", spec)
+		}
+		return func(ctx *fasthttp.RequestCtx) string {
+			if v := ctx.Request.Header.Peek(arg); len(v) > 0 {
+				return "header:" + string(v)
+			}
+			return ClientIPKey(ctx)
+		}, nil
+	case "jwt":
+		if arg == "" {
+			return nil, fmt.Errorf("rate limit key %q: missing claim name", spec)
+		}
+		return func(ctx *fasthttp.RequestCtx) string {
+			if v := jwtClaim(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization), arg); v != "" {
+				return "jwt:" + v
+			}
+			return ClientIPKey(ctx)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown rate limit key %q", spec)
+}
+
+// ClientIPKey limits requests by client IP
+func ClientIPKey(ctx *fasthttp.RequestCtx) string {
+	return "ip:" + realip.ClientIP(ctx)
+}
+
+// jwtClaim returns a claim from a bearer token's payload as a string
+func jwtClaim(authorization []byte, claim string) string {
+	token, ok := strings.CutPrefix(string(authorization), "Bearer ")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	switch v := claims[claim].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// SetHeaders writes the RateLimit-* headers, and Retry-After if the request was denied
+func (l *Limiter) SetHeaders(h *fasthttp.ResponseHeader, r Result) {
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", l.Requests, seconds(l.Period), l.Burst))
+	h.Set("RateLimit-Limit", strconv.Itoa(r.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(r.Reset)))
+	if !r.Allowed {
+		h.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(seconds(r.RetryAfter)))
+	}
+}
+
+// seconds rounds up, so clients never retry early
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func token(payload string) string {
+	return "Bearer e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+}
+
+func TestParseKey(t *testing.T) {
+	for _, tt := range []struct {
+		spec    string
+		path    string
+		headers map[string]string
+		want    string
+	}{
+		{"", "/a", nil, "ip:192.0.2.1"},
+		{"ip", "/a", nil, "ip:192.0.2.1"},
+		{"path", "/a/b", nil, "path:/a/b"},
+		{"header:X-Api-Key", "/a", map[string]string{"X-Api-Key": "k1"}, "header:k1"},
+		{"header:X-Api-Key", "/a", nil, "ip:192.0.2.1"},
+		{"jwt:sub", "/a", map[string]string{"Authorization": token(`{"sub":"alice"}`)}, "jwt:alice"},
+		{"jwt:org", "/a", map[string]string{"Authorization": token(`{"org":42}`)}, "jwt:42"},
+		{"jwt:sub", "/a", map[string]string{"Authorization": token(`{"org":42}`)}, "ip:192.0.2.1"},
+		{"jwt:sub", "/a", map[string]string{"Authorization": token(`{"sub":["a"]}`)}, "ip:192.0.2.1"},
+		{"jwt:sub", "/a", map[string]string{"Authorization": "Basic YWxpY2U6cHc="}, "ip:192.0.2.1"},
+		{"jwt:sub", "/a", map[string]string{"Authorization": "Bearer not-a-jwt"}, "ip:192.0.2.1"},
+		{"jwt:sub", "/a", map[string]string{"Authorization": "Bearer a.!!!.c"}, "ip:192.0.2.1"},
+	} {
+		key, err := ParseKey(tt.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.spec, err)
+		}
+		var req fasthttp.Request
+		req.SetRequestURI(tt.path)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		var ctx fasthttp.RequestCtx
+		ctx.Init(&req, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}, nil)
+		if got := key(&ctx); got != tt.want {
+			t.Errorf("%q with %v: key %q, want %q", tt.spec, tt.headers, got, tt.want)
+		}
+	}
+}
+
+func TestParseKeyRejects(t *testing.T) {
+	for _, spec := range []string{"header", "header:", "jwt:", "cookie:id", "IP"} {
+		if _, err := ParseKey(spec); err == nil || !strings.Contains(err.Error(), "rate limit key") {
+			t.Errorf("%q: got %v, want an error", spec, err)
+		}
+	}
+}
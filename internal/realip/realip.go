@@ -0,0 +1,141 @@
+package realip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+// userValueKey caches the resolved client IP on the request context
+const userValueKey = "realip.client_ip"
+
+// Resolver resolves the real client IP for requests relayed by trusted proxies
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// NewResolver builds a Resolver from a list of CIDRs or bare IP addresses
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	trusted, err := ParseCIDRs(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+	}
+	return &Resolver{trusted: trusted}, nil
+}
+
+// ParseCIDRs parses CIDRs, accepting bare IP addresses as single-host networks
+func ParseCIDRs(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", entry)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			entry = fmt.Sprintf("%s/%d", ip.String(), bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// IsTrusted reports whether ip belongs to one of the trusted proxy ranges
+func (r *Resolver) IsTrusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client IP for the request.
+// The peer address is used unless it is a trusted proxy, in which case the
+// right-most untrusted X-Forwarded-For entry wins, then X-Real-IP.
+// Addresses recovered from a PROXY protocol header are already the peer address.
+func (r *Resolver) Resolve(ctx *fasthttp.RequestCtx) string {
+	peer := ctx.RemoteIP()
+	if !r.IsTrusted(peer) {
+		return peer.String()
+	}
+
+	if xff := ctx.Request.Header.Peek(fasthttp.HeaderXForwardedFor); len(xff) > 0 {
+		hops := strings.Split(string(xff), ",")
+		var leftmost net.IP
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				// A malformed hop cannot be trusted; stop walking the chain here
+				break
+			}
+			if !r.IsTrusted(ip) {
+				return ip.String()
+			}
+			leftmost = ip
+		}
+		if leftmost != nil {
+			return leftmost.String()
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(string(ctx.Request.Header.Peek("X-Real-IP")))); ip != nil {
+		return ip.String()
+	}
+
+	return peer.String()
+}
+
+var (
+	mu       sync.RWMutex
+	resolver = &Resolver{} // Trusts nobody until configured
+)
+
+// SetTrustedProxies replaces the trusted proxy list used by ClientIP
+func SetTrustedProxies(trustedProxies []string) error {
+	r, err := NewResolver(trustedProxies)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	resolver = r
+	mu.Unlock()
+	return nil
+}
+
+// IsTrustedProxy reports whether ip is one of the configured trusted proxies
+func IsTrustedProxy(ip net.IP) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return resolver.IsTrusted(ip)
+}
+
+// ClientIP returns the resolved client IP, caching it on the request context
+// so the firewall, balancer and access logs all see the same address
+func ClientIP(ctx *fasthttp.RequestCtx) string {
+	if ip, ok := ctx.UserValue(userValueKey).(string); ok {
+		return ip
+	}
+	mu.RLock()
+	ip := resolver.Resolve(ctx)
+	mu.RUnlock()
+	ctx.SetUserValue(userValueKey, ip)
+	return ip
+}
@@ -0,0 +1,224 @@
+package rewrite
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Rule rewrites or redirects requests whose path matches Match
+type Rule struct {
+	Match       string            // Regexp on the path; empty matches every path
+	Path        string            // Replacement with $1 or ${name} captures; may carry a query, empty keeps the path
+	Host        string            // Host sent upstream, or the redirect's host
+	Scheme      string            // http or https, redirects only
+	AddQuery    map[string]string // Set after RemoveQuery; values may use captures
+	RemoveQuery []string
+	Redirect    int // 301, 302, 307 or 308 answers without a backend; 0 rewrites
+}
+
+type rule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// Rules are tried in order and the first match applies
+type Rules struct {
+	rules []rule
+}
+
+// New compiles rules
+func New(rules []Rule) (*Rules, error) {
+	r := &Rules{}
+	for i, c := range rules {
+		compiled := rule{Rule: c}
+		if c.Match != "" {
+			re, err := regexp.Compile(c.Match)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			compiled.re = re
+		}
+		switch c.Redirect {
+		case 0, fasthttp.StatusMovedPermanently, fasthttp.StatusFound,
+			fasthttp.StatusTemporaryRedirect, fasthttp.StatusPermanentRedirect:
+		default:
+			return nil, fmt.Errorf("rule %d: redirect must be 301, 302, 307 or 308, not %d", i+1, c.Redirect)
+		}
+		switch c.Scheme {
+		case "", "http", "https":
+		default:
+			return nil, fmt.Errorf("rule %d: unknown scheme %q", i+1, c.Scheme)
+		}
+		if c.Scheme != "" && c.Redirect == 0 {
+			return nil, fmt.Errorf("rule %d: scheme needs a redirect", i+1)
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+// Redirects reports whether any rule answers with a redirect
+func (r *Rules) Redirects() bool {
+	if r == nil {
+		return false
+	}
+	for _, c := range r.rules {
+		if c.Redirect != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Result is where a request goes once the rules are applied
+type Result struct {
+	Path     string // Escaped, as sent on the wire
+	Query    string // Without the leading '?'
+	Host     string // Empty keeps the backend's host
+	Redirect int    // Non-zero to answer with Location instead of proxying
+	Location string
+}
+
+// URI is the path and query to request upstream
+func (res *Result) URI() string {
+	uri := res.Path
+	if uri == "" {
+		uri = "/"
+	}
+	if res.Query != "" {
+		uri += "?" + res.Query
+	}
+	return uri
+}
+
+// Unchanged is the target of a request no rule matched; path is escaped
+func Unchanged(path, query string) *Result {
+	return &Result{Path: path, Query: query}
+}
+
+// Apply runs the first rule matching the request's path. Rules match the
+// decoded path; nil rules leave the request unchanged.
+func (r *Rules) Apply(uri *fasthttp.URI) *Result {
+	path := string(uri.Path())
+	res := Unchanged(wirePath(uri), string(uri.QueryString()))
+	if r == nil {
+		return res
+	}
+
+	for i := range r.rules {
+		c := &r.rules[i]
+		var match []int
+		if c.re != nil {
+			if match = c.re.FindStringSubmatchIndex(path); match == nil {
+				continue
+			}
+		}
+		expand := func(template string) string {
+			if c.re == nil {
+				return template
+			}
+			return string(c.re.ExpandString(nil, template, path, match))
+		}
+		// Captures in the query are escaped, so a captured & or = cannot
+		// add parameters
+		expandQuery := func(template string) string {
+			if c.re == nil {
+				return template
+			}
+			return expandEscaped(c.re, template, path, match, url.QueryEscape)
+		}
+
+		if c.Path != "" {
+			// Split the template, not its expansion: captures are decoded, and
+			// a captured %3F must stay in the path
+			newPath, query, hasQuery := strings.Cut(c.Path, "?")
+			res.Path = escapePath(expand(newPath))
+			if hasQuery {
+				res.Query = expandQuery(query)
+			}
+		}
+		if len(c.RemoveQuery) > 0 || len(c.AddQuery) > 0 {
+			res.Query = editQuery(res.Query, c.RemoveQuery, c.AddQuery, expand)
+		}
+		res.Host = c.Host
+
+		if c.Redirect != 0 {
+			res.Redirect = c.Redirect
+			res.Location = location(uri, c.Scheme, res)
+		}
+		return res
+	}
+	return res
+}
+
+// expandEscaped is ExpandString with every capture passed through escape
+func expandEscaped(re *regexp.Regexp, template, src string, match []int, escape func(string) string) string {
+	var escaped strings.Builder
+	indexes := make([]int, len(match))
+	for i := 0; i < len(match); i += 2 {
+		if match[i] < 0 {
+			indexes[i], indexes[i+1] = -1, -1
+			continue
+		}
+		indexes[i] = escaped.Len()
+		escaped.WriteString(escape(src[match[i]:match[i+1]]))
+		indexes[i+1] = escaped.Len()
+	}
+	return string(re.ExpandString(nil, template, escaped.String(), indexes))
+}
+
+// wirePath is the path as the client sent it, so escapes such as %2F and
+// %3F reach the backend intact. If normalizing it removed dot segments or
+// duplicate slashes, the normalized path that routes matched is sent instead.
+func wirePath(uri *fasthttp.URI) string {
+	original := string(uri.PathOriginal())
+	if decoded, err := url.PathUnescape(original); err == nil && decoded == string(uri.Path()) {
+		return original
+	}
+	return escapePath(string(uri.Path()))
+}
+
+// escapePath escapes a decoded path for the request line, keeping slashes
+func escapePath(path string) string {
+	return (&url.URL{Path: path}).EscapedPath()
+}
+
+// editQuery removes, then sets query parameters, in name order so the
+// result is the same for every request
+func editQuery(query string, remove []string, add map[string]string, expand func(string) string) string {
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	args.Parse(query)
+	for _, name := range remove {
+		args.Del(name)
+	}
+	names := make([]string, 0, len(add))
+	for name := range add {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args.Set(name, expand(add[name]))
+	}
+	return string(args.QueryString())
+}
+
+// location is relative unless the rule changes the scheme or host
+func location(uri *fasthttp.URI, scheme string, res *Result) string {
+	if scheme == "" && res.Host == "" {
+		return res.URI()
+	}
+	if scheme == "" {
+		scheme = string(uri.Scheme())
+	}
+	host := res.Host
+	if host == "" {
+		host = string(uri.Host())
+	}
+	return scheme + "://" + host + res.URI()
+}
@@ -0,0 +1,58 @@
+package rewrite
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestApplyKeepsEscapedPaths(t *testing.T) {
+	rules, err := New([]Rule{{Match: "^/old/(.*)$", Path: "/new/${1}"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for requestURI, want := range map[string]string{
+		"/a%3Fb?x=1":      "/a%3Fb?x=1", // No rule matches: sent as the client wrote it
+		"/a%2Fb":          "/a%2Fb",
+		"/a%23b":          "/a%23b",
+		"/x/..%2Fadmin":   "/admin", // Normalized, as routes matched it
+		"/old/a%3Fb?x=1":  "/new/a%3Fb?x=1",
+		"/old/a%20b%23c":  "/new/a%20b%23c",
+		"/old/plain/path": "/new/plain/path",
+	} {
+		var uri fasthttp.URI
+		uri.Parse(nil, []byte(requestURI))
+		if got := rules.Apply(&uri).URI(); got != want {
+			t.Errorf("%s: upstream URI %q, want %q", requestURI, got, want)
+		}
+	}
+}
+
+func TestApplyEscapesCapturesInTheQuery(t *testing.T) {
+	rules, err := New([]Rule{
+		{Match: "^/old/([^/]*)$", Path: "/new?q=$1&lang=en"},
+		{Match: "^/add/(?P<v>.*)$", AddQuery: map[string]string{"v": "${v}"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for requestURI, want := range map[string]string{
+		"/old/a%26b":       "/new?q=a%26b&lang=en", // Not a second parameter
+		"/old/a%3Db%20c":   "/new?q=a%3Db+c&lang=en",
+		"/old/plain":       "/new?q=plain&lang=en",
+		"/add/x%26admin=1": "/add/x%26admin=1?v=x%26admin%3D1",
+	} {
+		var uri fasthttp.URI
+		uri.Parse(nil, []byte(requestURI))
+		res := rules.Apply(&uri)
+		if got := res.URI(); got != want {
+			t.Errorf("%s: upstream URI %q, want %q", requestURI, got, want)
+		}
+		args := fasthttp.AcquireArgs()
+		args.Parse(res.Query)
+		if n := args.Len(); n > 2 {
+			t.Errorf("%s: query %q has %d parameters", requestURI, res.Query, n)
+		}
+		fasthttp.ReleaseArgs(args)
+	}
+}
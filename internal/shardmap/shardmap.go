@@ -0,0 +1,129 @@
+package shardmap
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+)
+
+// shardCount spreads keys over enough locks that concurrent requests rarely
+// wait on each other
+const shardCount = 64
+
+// Map is a string-keyed map split into shards, each with its own lock and
+// least recently used order. It holds at most about maxKeys keys: when a
+// shard is full, its least recently used key is evicted.
+type Map[V any] struct {
+	seed     maphash.Seed
+	perShard int
+	shards   [shardCount]shard[V]
+}
+
+type shard[V any] struct {
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order list.List // Most recently used first
+}
+
+type entry[V any] struct {
+	key   string
+	value V
+}
+
+// New creates a map holding up to maxKeys keys
+func New[V any](maxKeys int) *Map[V] {
+	perShard := (maxKeys + shardCount - 1) / shardCount
+	if perShard < 1 {
+		perShard = 1
+	}
+	m := &Map[V]{seed: maphash.MakeSeed(), perShard: perShard}
+	for i := range m.shards {
+		m.shards[i].items = make(map[string]*list.Element)
+	}
+	return m
+}
+
+func (m *Map[V]) shard(key string) *shard[V] {
+	return &m.shards[maphash.String(m.seed, key)%shardCount]
+}
+
+// Update replaces key's value with what fn returns, given the current value
+// if there is one. fn runs under the shard's lock, so read-modify-write
+// cycles on the same key never lose updates; it must not use the map.
+// Returning false leaves the map unchanged.
+func (m *Map[V]) Update(key string, fn func(value V, found bool) (V, bool)) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, found := s.items[key]
+	var current V
+	if found {
+		current = el.Value.(*entry[V]).value
+	}
+	value, store := fn(current, found)
+	if !store {
+		return
+	}
+	if found {
+		el.Value.(*entry[V]).value = value
+		s.order.MoveToFront(el)
+		return
+	}
+	if s.order.Len() >= m.perShard {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(*entry[V]).key)
+	}
+	s.items[key] = s.order.PushFront(&entry[V]{key: key, value: value})
+}
+
+// Set stores a value for key
+func (m *Map[V]) Set(key string, value V) {
+	m.Update(key, func(V, bool) (V, bool) { return value, true })
+}
+
+// Get returns key's value without marking it as used
+func (m *Map[V]) Get(key string) (V, bool) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		return el.Value.(*entry[V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// DeleteFunc removes the keys for which fn returns true, one shard at a time
+func (m *Map[V]) DeleteFunc(fn func(key string, value V) bool) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		for el := s.order.Front(); el != nil; {
+			next := el.Next()
+			if e := el.Value.(*entry[V]); fn(e.key, e.value) {
+				s.order.Remove(el)
+				delete(s.items, e.key)
+			}
+			el = next
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Range calls fn for each key until it returns false. Each shard is locked
+// while it is visited, so fn must not use the map.
+func (m *Map[V]) Range(fn func(key string, value V) bool) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		for el := s.order.Front(); el != nil; el = el.Next() {
+			if e := el.Value.(*entry[V]); !fn(e.key, e.value) {
+				s.mu.Unlock()
+				return
+			}
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,203 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CertPair is a certificate/key file pair on disk
+type CertPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// CertStore serves certificates by SNI and reloads them from disk
+type CertStore struct {
+	pairs []CertPair
+
+	mu      sync.RWMutex
+	certs   []*tls.Certificate
+	modTime time.Time
+}
+
+// NewCertStore loads every pair; it fails if any pair cannot be loaded
+func NewCertStore(pairs []CertPair) (*CertStore, error) {
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("tls: no certificates configured")
+	}
+	cs := &CertStore{pairs: pairs}
+	if err := cs.Reload(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// Reload re-reads all certificates; on error the previous set stays active
+func (cs *CertStore) Reload() error {
+	certs := make([]*tls.Certificate, 0, len(cs.pairs))
+	for _, p := range cs.pairs {
+		cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tls: loading %s: %w", p.CertFile, err)
+		}
+		if cert.Leaf == nil {
+			if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return fmt.Errorf("tls: parsing %s: %w", p.CertFile, err)
+			}
+		}
+		certs = append(certs, &cert)
+	}
+
+	cs.mu.Lock()
+	cs.certs = certs
+	cs.modTime = cs.latestModTime()
+	cs.mu.Unlock()
+	return nil
+}
+
+// Watch reloads certificates whenever a file changes on disk, until ctx is
+// done. Reload errors are passed to onError and the old certificates are kept.
+func (cs *CertStore) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cs.mu.RLock()
+		changed := cs.latestModTime().After(cs.modTime)
+		cs.mu.RUnlock()
+
+		if changed {
+			if err := cs.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+func (cs *CertStore) latestModTime() time.Time {
+	var latest time.Time
+	for _, p := range cs.pairs {
+		for _, f := range []string{p.CertFile, p.KeyFile} {
+			if info, err := os.Stat(f); err == nil && info.ModTime().After(latest) {
+				latest = info.ModTime()
+			}
+		}
+	}
+	return latest
+}
+
+// GetCertificate picks the certificate matching the SNI name, falling back to the first one
+func (cs *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	if hello.ServerName != "" {
+		name := strings.ToLower(hello.ServerName)
+		for _, cert := range cs.certs {
+			if cert.Leaf.VerifyHostname(name) == nil {
+				return cert, nil
+			}
+		}
+	}
+	return cs.certs[0], nil
+}
+
+// Versions accepted in min_version
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewServerConfig builds the listener TLS config.
+// minVersion defaults to 1.2; cipherSuites uses Go's names and only affects TLS 1.2 and below.
+func NewServerConfig(cs *CertStore, minVersion string, cipherSuites []string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		GetCertificate: cs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if minVersion != "" {
+		v, ok := tlsVersions[strings.TrimPrefix(minVersion, "TLS")]
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown min_version %q", minVersion)
+		}
+		cfg.MinVersion = v
+	}
+
+	if len(cipherSuites) > 0 {
+		ids, err := CipherSuiteIDs(cipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CipherSuites = ids
+	}
+
+	return cfg, nil
+}
+
+// CipherSuiteIDs maps Go cipher suite names to their IDs
+func CipherSuiteIDs(names []string) ([]uint16, error) {
+	known := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		known[s.Name] = s.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// NewClientConfig builds the TLS config for connections to backends.
+// caFile replaces the system roots, certFile/keyFile enable mutual TLS and
+// serverName overrides the SNI and verification name.
+func NewClientConfig(caFile, certFile, keyFile, serverName string, insecureSkipVerify bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: reading CA bundle: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificates found in %s", caFile)
+		}
+		cfg.RootCAs = roots
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
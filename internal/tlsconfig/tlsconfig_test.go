@@ -0,0 +1,239 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA signs certificates for the tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, file, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue writes a certificate for names, signed by the CA, and returns its files
+func (ca *testCA) issue(t *testing.T, dir string, names ...string) CertPair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair := CertPair{CertFile: filepath.Join(dir, names[0]+".pem"), KeyFile: filepath.Join(dir, names[0]+".key")}
+	writePEM(t, pair.CertFile, "CERTIFICATE", der)
+	writePEM(t, pair.KeyFile, "EC PRIVATE KEY", keyDER)
+	return pair
+}
+
+func writePEM(t *testing.T, file, kind string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedName returns the first DNS name of the certificate chosen for an SNI name
+func servedName(t *testing.T, cs *CertStore, sni string) string {
+	t.Helper()
+	cert, err := cs.GetCertificate(&tls.ClientHelloInfo{ServerName: sni})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Leaf.DNSNames[0]
+}
+
+func TestSNISelectsMatchingCertificate(t *testing.T) {
+	ca, dir := newTestCA(t), t.TempDir()
+	cs, err := NewCertStore([]CertPair{
+		ca.issue(t, dir, "default.example"),
+		ca.issue(t, dir, "api.example"),
+		ca.issue(t, dir, "*.apps.example"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for sni, want := range map[string]string{
+		"api.example":       "api.example",
+		"API.Example":       "api.example",
+		"shop.apps.example": "*.apps.example",
+		"unknown.example":   "default.example", // Falls back to the first certificate
+		"":                  "default.example",
+	} {
+		if got := servedName(t, cs, sni); got != want {
+			t.Errorf("SNI %q got certificate %s, want %s", sni, got, want)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	ca, dir := newTestCA(t), t.TempDir()
+	pair := ca.issue(t, dir, "old.example")
+	cs, err := NewCertStore([]CertPair{pair})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A broken file fails the reload and keeps the loaded certificate
+	if err := os.WriteFile(pair.CertFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.Reload(); err == nil {
+		t.Fatal("reload of a broken certificate succeeded")
+	}
+	if got := servedName(t, cs, "old.example"); got != "old.example" {
+		t.Errorf("after a failed reload got %s, want old.example", got)
+	}
+
+	// Watch picks up a replacement written to the same files
+	renewed := ca.issue(t, t.TempDir(), "new.example")
+	for _, f := range [][2]string{{renewed.CertFile, pair.CertFile}, {renewed.KeyFile, pair.KeyFile}} {
+		data, _ := os.ReadFile(f[0])
+		os.WriteFile(f[1], data, 0o600)
+		later := time.Now().Add(time.Minute)
+		os.Chtimes(f[1], later, later)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		cs.Watch(ctx, 10*time.Millisecond, nil)
+		close(stopped)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for servedName(t, cs, "") != "new.example" {
+		if time.Now().After(deadline) {
+			t.Fatal("Watch did not reload the renewed certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Watch kept running once its context was done")
+	}
+}
+
+func TestClientCertificateRequiredByBackend(t *testing.T) {
+	ca, dir := newTestCA(t), t.TempDir()
+	serverPair := ca.issue(t, dir, "backend.example")
+	clientPair := ca.issue(t, dir, "client.example")
+
+	serverCert, err := tls.LoadX509KeyPair(serverPair.CertFile, serverPair.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if tlsConn := conn.(*tls.Conn); tlsConn.Handshake() == nil {
+					conn.Write([]byte("ok"))
+				}
+			}()
+		}
+	}()
+
+	exchange := func(cfg *tls.Config) error {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), cfg)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		// With TLS 1.3 a rejected client certificate shows up on the first read
+		_, err = conn.Read(make([]byte, 2))
+		return err
+	}
+
+	withoutCert, err := NewClientConfig(ca.file, "", "", "backend.example", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exchange(withoutCert); err == nil {
+		t.Error("backend accepted a client without a certificate")
+	}
+
+	withCert, err := NewClientConfig(ca.file, clientPair.CertFile, clientPair.KeyFile, "backend.example", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exchange(withCert); err != nil {
+		t.Errorf("client certificate rejected: %v", err)
+	}
+
+	// The backend's name is verified against the CA bundle
+	wrongName, _ := NewClientConfig(ca.file, clientPair.CertFile, clientPair.KeyFile, "other.example", false)
+	if err := exchange(wrongName); err == nil {
+		t.Error("backend certificate accepted for the wrong server name")
+	}
+}
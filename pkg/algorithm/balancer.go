@@ -0,0 +1,25 @@
+package algorithm
+
+import (
+	"net/url"
+
+	"github.com/siddhu949/leanbalancer/internal/health"
+)
+
+// Balancer selects a backend for a client request
+type Balancer interface {
+	GetNextBackend(clientIP string) *url.URL
+}
+
+// NewBalancer returns the balancer for the configured algorithm name,
+// falling back to round robin for unknown names
+func NewBalancer(name string, hc *health.HealthChecker) Balancer {
+	switch name {
+	case "ip_hash":
+		return NewIPHash(hc)
+	case "least_connections":
+		return NewLeastConnections(hc)
+	default:
+		return NewRoundRobin(hc)
+	}
+}
@@ -0,0 +1,31 @@
+package algorithm
+
+import (
+	"hash/fnv"
+	"net/url"
+
+	"github.com/siddhu949/leanbalancer/internal/health"
+)
+
+// IPHash pins each client IP to a backend
+type IPHash struct {
+	healthChecker *health.HealthChecker
+}
+
+// NewIPHash initializes IP hash balancing with health check
+func NewIPHash(hc *health.HealthChecker) *IPHash {
+	return &IPHash{healthChecker: hc}
+}
+
+// GetNextBackend selects the backend for the client IP
+func (ih *IPHash) GetNextBackend(clientIP string) *url.URL {
+	healthyBackends := ih.healthChecker.GetHealthyBackends()
+	if len(healthyBackends) == 0 {
+		return nil // No available servers
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(clientIP))
+	backend, _ := url.Parse(healthyBackends[h.Sum32()%uint32(len(healthyBackends))])
+	return backend
+}
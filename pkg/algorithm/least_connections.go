@@ -0,0 +1,75 @@
+package algorithm
+
+import (
+	"net/url"
+	"sync"
+
+	"github.com/siddhu949/leanbalancer/internal/health"
+)
+
+// ConnectionTracker is implemented by balancers that count in-flight requests.
+// Release must be called once for every backend returned by GetNextBackend.
+type ConnectionTracker interface {
+	Release(backend *url.URL)
+}
+
+// LeastConnections picks the healthy backend with the fewest in-flight requests
+type LeastConnections struct {
+	healthChecker *health.HealthChecker
+	mu            sync.Mutex
+	active        map[string]int
+}
+
+// NewLeastConnections initializes least-connections balancing with health check
+func NewLeastConnections(hc *health.HealthChecker) *LeastConnections {
+	return &LeastConnections{healthChecker: hc, active: map[string]int{}}
+}
+
+// GetNextBackend selects the least loaded backend and counts it as in use
+func (lc *LeastConnections) GetNextBackend(clientIP string) *url.URL {
+	healthyBackends := lc.healthChecker.GetHealthyBackends()
+	if len(healthyBackends) == 0 {
+		return nil // No available servers
+	}
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	best := healthyBackends[0]
+	for _, b := range healthyBackends[1:] {
+		if lc.active[b] < lc.active[best] {
+			best = b
+		}
+	}
+
+	backend, err := url.Parse(best)
+	if err != nil {
+		return nil
+	}
+	lc.active[best]++
+	return backend
+}
+
+// Release marks a request or connection to backend as finished
+func (lc *LeastConnections) Release(backend *url.URL) {
+	key := backend.String()
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.active[key] <= 1 {
+		delete(lc.active, key)
+		return
+	}
+	lc.active[key]--
+}
+
+// ActiveConnections returns the in-flight count per backend
+func (lc *LeastConnections) ActiveConnections() map[string]int {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	counts := make(map[string]int, len(lc.active))
+	for k, v := range lc.active {
+		counts[k] = v
+	}
+	return counts
+}
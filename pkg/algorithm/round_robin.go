@@ -20,7 +20,7 @@ func NewRoundRobin(hc *health.HealthChecker) *RoundRobin {
 }
 
 // GetNextBackend selects the next available backend
-func (rr *RoundRobin) GetNextBackend() *url.URL {
+func (rr *RoundRobin) GetNextBackend(clientIP string) *url.URL {
 	rr.mu.Lock()
 	defer rr.mu.Unlock()
 
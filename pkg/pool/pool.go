@@ -1,7 +1,10 @@
 package pool
 
 import (
+	"crypto/tls"
+	"net"
 	"sync"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
@@ -9,11 +12,83 @@ import (
 var (
 	clientPool = sync.Pool{
 		New: func() interface{} {
-			return &fasthttp.Client{} // Memory-efficient HTTP client reuse
+			return newClient(nil, nil) // Memory-efficient HTTP client reuse
 		},
 	}
+
+	// Non-zero when response bodies are streamed instead of buffered
+	streamIdleTimeout time.Duration
 )
 
+// Responses up to this size are still read in one go; larger ones are streamed
+const streamThreshold = 64 * 1024
+
+// EnableStreaming makes clients stream response bodies; connections that see
+// no traffic for idleTimeout are closed. Call before any client is created.
+func EnableStreaming(idleTimeout time.Duration) {
+	streamIdleTimeout = idleTimeout
+}
+
+// StreamingEnabled reports whether clients stream response bodies
+func StreamingEnabled() bool {
+	return streamIdleTimeout > 0
+}
+
+// DialFunc opens a connection to addr (host:port)
+type DialFunc func(addr string) (net.Conn, error)
+
+// Clients send request paths as given, so escapes such as %2F and %3F reach
+// backends intact instead of being decoded and normalized again
+func newClient(tlsConfig *tls.Config, dial DialFunc) *fasthttp.Client {
+	if !StreamingEnabled() {
+		return &fasthttp.Client{TLSConfig: tlsConfig, Dial: fasthttp.DialFunc(dial), DisablePathNormalizing: true}
+	}
+
+	// A whole-response ReadTimeout would cut off long downloads, so the
+	// deadline is refreshed on every read and write instead
+	idle := streamIdleTimeout
+	if dial == nil {
+		dial = func(addr string) (net.Conn, error) {
+			return fasthttp.DialTimeout(addr, idle)
+		}
+	}
+	return &fasthttp.Client{
+		TLSConfig:              tlsConfig,
+		StreamResponseBody:     true,
+		MaxResponseBodySize:    streamThreshold,
+		DisablePathNormalizing: true,
+		Dial: func(addr string) (net.Conn, error) {
+			conn, err := dial(addr)
+			if err != nil {
+				return nil, err
+			}
+			return &idleTimeoutConn{Conn: conn, timeout: idle}, nil
+		},
+	}
+}
+
+// idleTimeoutConn extends its deadline on every read and write
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
+
+// NewClient creates a client outside any pool, e.g. for connections that
+// must not be shared; dial may be nil for the default dialer
+func NewClient(tlsConfig *tls.Config, dial DialFunc) *fasthttp.Client {
+	return newClient(tlsConfig, dial)
+}
+
 // GetClient retrieves an HTTP client from the pool
 func GetClient() *fasthttp.Client {
 	return clientPool.Get().(*fasthttp.Client)
@@ -23,3 +98,33 @@ func GetClient() *fasthttp.Client {
 func ReleaseClient(client *fasthttp.Client) {
 	clientPool.Put(client)
 }
+
+// ClientPool reuses HTTP clients that share a backend TLS config
+type ClientPool struct {
+	pool sync.Pool
+}
+
+// NewClientPool creates a client pool; tlsConfig is used for https:// backends
+func NewClientPool(tlsConfig *tls.Config) *ClientPool {
+	return NewClientPoolWithDial(tlsConfig, nil)
+}
+
+// NewClientPoolWithDial creates a client pool whose clients open connections
+// with dial, e.g. to apply a connect timeout; nil uses the default dialer
+func NewClientPoolWithDial(tlsConfig *tls.Config, dial DialFunc) *ClientPool {
+	cp := &ClientPool{}
+	cp.pool.New = func() interface{} {
+		return newClient(tlsConfig, dial)
+	}
+	return cp
+}
+
+// Get retrieves an HTTP client from the pool
+func (cp *ClientPool) Get() *fasthttp.Client {
+	return cp.pool.Get().(*fasthttp.Client)
+}
+
+// Release returns an HTTP client to the pool
+func (cp *ClientPool) Release(client *fasthttp.Client) {
+	cp.pool.Put(client)
+}
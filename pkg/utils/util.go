@@ -6,6 +6,6 @@ import (
 )
 
 // LogRequest logs details about each request
-func LogRequest(method, path string, status int, duration time.Duration) {
-	log.Printf("[%s] %s -> %d (%v)\n", method, path, status, duration)
+func LogRequest(clientIP, method, path string, status int, duration time.Duration) {
+	log.Printf("%s [%s] %s -> %d (%v)\n", clientIP, method, path, status, duration)
 }